@@ -0,0 +1,513 @@
+package block
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// BackupOutputFormat selects where backup block payloads are written.
+type BackupOutputFormat int
+
+const (
+	// BackupOutputFormatFile writes each unique block to its own file
+	// under BackupConfig.OutputDirectory, named by content hash.
+	BackupOutputFormatFile BackupOutputFormat = iota
+
+	// BackupOutputFormatSTDOUT streams blocks to stdout instead of disk.
+	// Not yet implemented; see the commented-out TestBackupToStdout.
+	// BackupOutputFormatSTDOUT
+
+	// BackupOutputFormatObjectStore streams each changed block straight
+	// into an ObjectStore under a content-addressed key, plus a small
+	// JSON manifest per backup, instead of writing to OutputDirectory.
+	BackupOutputFormatObjectStore
+)
+
+const (
+	backupTypeFull         = "full"
+	backupTypeDifferential = "differential"
+	backupTypeIncremental  = "incremental"
+)
+
+// BackupMode selects how a Backup picks its diff baseline.
+type BackupMode string
+
+const (
+	// BackupModeAuto (the zero value) preserves the historical behavior:
+	// full if no prior full backup exists for the device, differential
+	// (always against the most recent full) otherwise.
+	BackupModeAuto BackupMode = ""
+
+	// BackupModeFull always takes a full backup.
+	BackupModeFull BackupMode = "full"
+
+	// BackupModeDifferential always diffs against the most recent full
+	// backup, taking a full backup instead if none exists.
+	BackupModeDifferential BackupMode = "differential"
+
+	// BackupModeIncremental diffs against the immediately preceding
+	// backup (full or incremental), taking a full backup instead if none
+	// exists, forming a chain. See BackupConfig.MaxIncrementalChain.
+	BackupModeIncremental BackupMode = "incremental"
+)
+
+// BackupConfig configures a single Backup run.
+type BackupConfig struct {
+	// Store persists backup/block/position metadata. If nil, StoreDSN is
+	// used to open one.
+	Store Store
+
+	// StoreDSN opens a Store when Store is nil, e.g. "sqlite://backups.db"
+	// or "bolt://backups.bolt". Ignored when Store is set.
+	StoreDSN string
+
+	// DevicePath is the block device (or device image) to back up.
+	DevicePath string
+
+	// OutputFormat selects where block payloads are written.
+	OutputFormat BackupOutputFormat
+
+	// OutputDirectory is the root directory block payloads are written
+	// under when OutputFormat is BackupOutputFormatFile.
+	OutputDirectory string
+
+	// ObjectStore is where block payloads and manifests are written when
+	// OutputFormat is BackupOutputFormatObjectStore. If nil, Destination
+	// is used to open one.
+	ObjectStore ObjectStore
+
+	// Destination opens an ObjectStore when ObjectStore is nil, e.g.
+	// "s3://bucket/prefix" or "file:///var/lib/block-diff/backups".
+	// Ignored when ObjectStore is set.
+	Destination string
+
+	// BlockSize is the size, in bytes, of each block read from the
+	// device.
+	BlockSize int
+
+	// BlockBufferSize bounds how many blocks are hashed and written
+	// concurrently.
+	BlockBufferSize int
+
+	// Mode selects how this backup picks its diff baseline. Defaults to
+	// BackupModeAuto.
+	Mode BackupMode
+
+	// MaxIncrementalChain caps how many incremental backups may chain off
+	// the same full backup before the next one is taken as a full backup
+	// instead, resetting the chain. Zero means unbounded. Only consulted
+	// when Mode is BackupModeIncremental. See CollapseChain for folding
+	// an existing chain back into a full backup on demand.
+	MaxIncrementalChain int
+
+	// Encryption, if set, encrypts every block this backup writes with
+	// AES-256-GCM under a key unique to this backup. Leave nil to write
+	// plaintext blocks, as before.
+	Encryption *EncryptionConfig
+}
+
+// Backup reads a device in BlockSize chunks, diffing against a baseline
+// chosen by Config.Mode (if any) and recording only the blocks that
+// changed.
+type Backup struct {
+	Config *BackupConfig
+	Record *BackupRecord
+
+	store Store
+	vol   *volume
+	enc   *blockEncrypter
+}
+
+// NewBackup opens cfg.DevicePath and prepares a Backup. The backup itself
+// isn't performed until Run is called.
+func NewBackup(cfg *BackupConfig) (*Backup, error) {
+	if cfg.Store == nil {
+		store, err := NewStoreFromDSN(cfg.StoreDSN)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Store = store
+	}
+
+	if cfg.OutputFormat == BackupOutputFormatObjectStore && cfg.ObjectStore == nil {
+		store, err := NewObjectStoreFromURL(cfg.Destination)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ObjectStore = store
+	}
+
+	if cfg.BlockSize <= 0 {
+		return nil, fmt.Errorf("block: BackupConfig.BlockSize must be positive")
+	}
+
+	vol, err := openVolume(cfg.DevicePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Backup{
+		Config: cfg,
+		store:  cfg.Store,
+		vol:    vol,
+	}, nil
+}
+
+// BackupType returns the type of backup that was performed ("full",
+// "differential" or "incremental"). It's only meaningful after Run has
+// returned successfully.
+func (b *Backup) BackupType() string {
+	if b.Record == nil {
+		return ""
+	}
+	return b.Record.BackupType
+}
+
+// TotalBlocks returns the number of blocks the device was divided into.
+// It's only meaningful after Run has returned successfully.
+func (b *Backup) TotalBlocks() int {
+	if b.Record == nil {
+		return 0
+	}
+	return b.Record.TotalBlocks
+}
+
+// Run performs the backup: it determines the backup type and diff baseline
+// per Config.Mode, reads the device in Config.BlockSize chunks, and records
+// each changed block's position and payload.
+func (b *Backup) Run() error {
+	if err := b.vol.reopen(); err != nil {
+		return err
+	}
+	defer b.vol.Close()
+
+	total := b.vol.totalBlocks(b.Config.BlockSize)
+
+	rec, baselinePositions, err := b.prepareBackup(total)
+	if err != nil {
+		return err
+	}
+
+	if err := b.store.CreateBackup(rec); err != nil {
+		return err
+	}
+
+	enc, err := newBlockEncrypter(b.store, b.Config.Encryption, rec.ID)
+	if err != nil {
+		return err
+	}
+	b.enc = enc
+
+	if err := b.writeBlocks(rec, total, baselinePositions); err != nil {
+		return err
+	}
+
+	if b.Config.OutputFormat == BackupOutputFormatObjectStore {
+		if err := b.writeManifest(rec); err != nil {
+			return err
+		}
+	}
+
+	b.Record = rec
+	return nil
+}
+
+// writeManifest writes the JSON manifest describing the blocks this backup
+// recorded to the object store.
+func (b *Backup) writeManifest(rec *BackupRecord) error {
+	positions, err := b.store.findBlockPositionsByBackup(rec.ID)
+	if err != nil {
+		return err
+	}
+
+	hashes := make([]string, len(positions))
+	for i, p := range positions {
+		hashes[i] = p.Hash
+	}
+
+	manifest := &BackupManifest{
+		BackupID:       rec.ID,
+		DevicePath:     rec.DevicePath,
+		BackupType:     rec.BackupType,
+		BlockSize:      rec.BlockSize,
+		TotalBlocks:    rec.TotalBlocks,
+		ParentBackupID: rec.ParentBackupID,
+		BlockHashes:    hashes,
+	}
+
+	r, err := encodeManifest(manifest)
+	if err != nil {
+		return err
+	}
+
+	return b.Config.ObjectStore.Put(manifestKey(rec.ID), r)
+}
+
+// prepareBackup determines the backup type, parent linkage, and diff
+// baseline for this run according to Config.Mode.
+func (b *Backup) prepareBackup(total int) (*BackupRecord, map[int]string, error) {
+	switch b.Config.Mode {
+	case BackupModeIncremental:
+		return b.prepareIncrementalBackup(total)
+	case BackupModeFull:
+		return &BackupRecord{
+			DevicePath:  b.Config.DevicePath,
+			BackupType:  backupTypeFull,
+			BlockSize:   b.Config.BlockSize,
+			TotalBlocks: total,
+		}, nil, nil
+	}
+	return b.prepareFullOrDifferentialBackup(total)
+}
+
+// prepareFullOrDifferentialBackup implements BackupModeAuto and
+// BackupModeDifferential: diff against the most recent full backup, or take
+// a full backup if none exists yet.
+func (b *Backup) prepareFullOrDifferentialBackup(total int) (*BackupRecord, map[int]string, error) {
+	baseline, baselinePositions, err := b.loadBaseline()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rec := &BackupRecord{
+		DevicePath:  b.Config.DevicePath,
+		BackupType:  backupTypeFull,
+		BlockSize:   b.Config.BlockSize,
+		TotalBlocks: total,
+	}
+
+	if baseline != nil {
+		rec.BackupType = backupTypeDifferential
+		rec.ParentBackupID = &baseline.ID
+	}
+
+	return rec, baselinePositions, nil
+}
+
+// prepareIncrementalBackup implements BackupModeIncremental: diff against
+// the immediately preceding backup (full or incremental), starting a fresh
+// chain with a full backup if none exists yet or the existing chain has
+// reached Config.MaxIncrementalChain.
+func (b *Backup) prepareIncrementalBackup(total int) (*BackupRecord, map[int]string, error) {
+	newFull := func() (*BackupRecord, map[int]string, error) {
+		return &BackupRecord{
+			DevicePath:  b.Config.DevicePath,
+			BackupType:  backupTypeFull,
+			BlockSize:   b.Config.BlockSize,
+			TotalBlocks: total,
+		}, nil, nil
+	}
+
+	parent, err := b.store.LatestBackupAnyType(b.Config.DevicePath)
+	if err == ErrBackupNotFound {
+		return newFull()
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	chainLen, err := incrementalChainLength(b.store, parent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if max := b.Config.MaxIncrementalChain; max > 0 && chainLen+1 > max {
+		return newFull()
+	}
+
+	baseline, _, err := chainPositions(b.store, parent.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parentID := parent.ID
+	rec := &BackupRecord{
+		DevicePath:     b.Config.DevicePath,
+		BackupType:     backupTypeIncremental,
+		BlockSize:      b.Config.BlockSize,
+		TotalBlocks:    total,
+		ParentBackupID: &parentID,
+	}
+
+	return rec, baseline, nil
+}
+
+// loadBaseline returns the most recent full backup of this device and its
+// offset -> hash map, or (nil, nil, nil) if this is the first backup.
+func (b *Backup) loadBaseline() (*BackupRecord, map[int]string, error) {
+	baseline, err := b.store.LatestBackup(b.Config.DevicePath, backupTypeFull)
+	if err == ErrBackupNotFound {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	positions, err := b.store.findBlockPositionsByBackup(baseline.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byOffset := make(map[int]string, len(positions))
+	for _, p := range positions {
+		byOffset[p.Offset] = p.Hash
+	}
+
+	return baseline, byOffset, nil
+}
+
+// writeBlocks reads every block of the volume, recording (and, for changed
+// blocks, persisting) the ones that differ from baseline. Up to
+// Config.BlockBufferSize blocks are processed concurrently.
+func (b *Backup) writeBlocks(rec *BackupRecord, total int, baseline map[int]string) error {
+	bufSize := b.Config.BlockBufferSize
+	if bufSize <= 0 {
+		bufSize = 1
+	}
+
+	sem := make(chan struct{}, bufSize)
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		firstErr error
+	)
+
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+	}
+
+	for offset := 0; offset < total; offset++ {
+		offset := offset
+		sem <- struct{}{}
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := b.processBlock(rec, offset, baseline); err != nil {
+				fail(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// processBlock hashes the block at offset and, if it differs from the
+// baseline (or there is no baseline), persists it and records its position.
+// The hash recorded in Store is always of the plaintext, even when
+// Config.Encryption is set, so dedup keeps working across encrypted
+// backups taken with the same key.
+func (b *Backup) processBlock(rec *BackupRecord, offset int, baseline map[int]string) error {
+	data, err := b.vol.readBlockAt(offset, b.Config.BlockSize)
+	if err != nil {
+		return err
+	}
+
+	hash := blockHash(data)
+
+	if baseline != nil && baseline[offset] == hash {
+		return nil
+	}
+
+	wrote, err := b.writeBlockPayload(rec.ID, offset, hash, data)
+	if err != nil {
+		return err
+	}
+
+	if wrote {
+		if _, err := b.store.RecordBlock(hash, rec.ID, offset); err != nil {
+			return err
+		}
+	}
+
+	return b.store.RecordPosition(rec.ID, offset, hash)
+}
+
+// writeBlockPayload writes data to OutputDirectory or ObjectStore, named by
+// hash, if it hasn't already been written by an earlier backup with the
+// same content, encrypting it first if Config.Encryption is set. It
+// reports whether the payload was actually written, which is also when
+// (backupID, offset) becomes that block's recorded origin.
+func (b *Backup) writeBlockPayload(backupID int64, offset int, hash string, data []byte) (bool, error) {
+	switch b.Config.OutputFormat {
+	case BackupOutputFormatFile:
+		return b.writeBlockToFile(backupID, offset, hash, data)
+	case BackupOutputFormatObjectStore:
+		return b.writeBlockToObjectStore(backupID, offset, hash, data)
+	default:
+		return false, fmt.Errorf("block: unsupported BackupOutputFormat %d", b.Config.OutputFormat)
+	}
+}
+
+func (b *Backup) writeBlockToFile(backupID int64, offset int, hash string, data []byte) (bool, error) {
+	dir := filepath.Join(b.Config.OutputDirectory, "blocks")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, fmt.Errorf("block: create block directory: %w", err)
+	}
+
+	path := filepath.Join(dir, hash+".blk")
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	}
+
+	payload, err := b.encryptPayload(backupID, offset, data)
+	if err != nil {
+		return false, err
+	}
+
+	// O_EXCL: of several goroutines racing to write the same hash, only
+	// the one that creates the file reports it as written.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("block: write block %s: %w", hash, err)
+	}
+	if _, err := f.Write(payload); err != nil {
+		f.Close()
+		return false, fmt.Errorf("block: write block %s: %w", hash, err)
+	}
+	if err := f.Close(); err != nil {
+		return false, fmt.Errorf("block: write block %s: %w", hash, err)
+	}
+
+	return true, nil
+}
+
+func (b *Backup) writeBlockToObjectStore(backupID int64, offset int, hash string, data []byte) (bool, error) {
+	key := blockKey(hash)
+
+	if _, exists, err := b.Config.ObjectStore.Stat(key); err != nil {
+		return false, err
+	} else if exists {
+		return false, nil
+	}
+
+	payload, err := b.encryptPayload(backupID, offset, data)
+	if err != nil {
+		return false, err
+	}
+
+	if err := b.Config.ObjectStore.Put(key, bytes.NewReader(payload)); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// encryptPayload returns data unchanged if Config.Encryption isn't set,
+// otherwise the AES-256-GCM ciphertext to write instead.
+func (b *Backup) encryptPayload(backupID int64, offset int, data []byte) ([]byte, error) {
+	if b.enc == nil {
+		return data, nil
+	}
+	return b.enc.encrypt(backupID, offset, data)
+}
@@ -1,13 +1,35 @@
 package block
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func setup(s *Store) {
+// storeBackends enumerates the Store implementations the backup test suite
+// runs against. Add a new backend here (and to the cleanup list below) to get
+// full coverage for free.
+var storeBackends = []struct {
+	name string
+	dsn  string
+}{
+	{name: "sqlite", dsn: "sqlite://backups.db"},
+	{name: "bolt", dsn: "bolt://backups.bolt"},
+}
+
+func newTestStore(t *testing.T, dsn string) Store {
+	store, err := NewStoreFromDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func setup(s Store) {
 	if err := s.SetupDB(); err != nil {
 		panic(err)
 	}
@@ -26,14 +48,10 @@ func cleanup(t *testing.T) {
 	if err := os.RemoveAll("restores/"); err != nil {
 		t.Log(err)
 	}
-	if err := os.Remove("backups.db"); err != nil {
-		t.Log(err)
-	}
-	if err := os.Remove("backups.db-shm"); err != nil {
-		t.Log(err)
-	}
-	if err := os.Remove("backups.db-wal"); err != nil {
-		t.Log(err)
+	for _, name := range []string{"backups.db", "backups.db-shm", "backups.db-wal", "backups.bolt"} {
+		if err := os.Remove(name); err != nil {
+			t.Log(err)
+		}
 	}
 }
 
@@ -43,11 +61,59 @@ const (
 )
 
 func TestFullBackup(t *testing.T) {
-	// Setup sqlite connection
-	store, err := NewStore()
+	for _, sb := range storeBackends {
+		t.Run(sb.name, func(t *testing.T) { testFullBackup(t, sb.dsn) })
+	}
+}
+
+// TestFullBackupObjectStore exercises BackupOutputFormatObjectStore against
+// an FS-backed ObjectStore instead of BackupOutputFormatFile.
+func TestFullBackupObjectStore(t *testing.T) {
+	store := newTestStore(t, "sqlite://backups.db")
+	defer store.Close()
+
+	setup(store)
+	defer cleanup(t)
+
+	cfg := &BackupConfig{
+		Store:           store,
+		DevicePath:      "assets/pg.ext4",
+		OutputFormat:    BackupOutputFormatObjectStore,
+		ObjectStore:     NewFSObjectStore("backups/"),
+		BlockSize:       1048576,
+		BlockBufferSize: 10,
+	}
+
+	b, err := NewBackup(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.TotalBlocks() != 50 {
+		t.Errorf("expected total chunks to be 50, got %d", b.TotalBlocks())
+	}
+
+	if _, exists, err := cfg.ObjectStore.Stat(manifestKey(b.Record.ID)); err != nil {
+		t.Fatal(err)
+	} else if !exists {
+		t.Fatalf("expected manifest to exist at %s", manifestKey(b.Record.ID))
+	}
+
+	keys, err := cfg.ObjectStore.List("blocks/")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if len(keys) != 37 {
+		t.Fatalf("expected 37 unique block objects, got %d", len(keys))
+	}
+}
+
+func testFullBackup(t *testing.T, dsn string) {
+	store := newTestStore(t, dsn)
 	defer store.Close()
 
 	setup(store)
@@ -109,11 +175,13 @@ func TestFullBackup(t *testing.T) {
 }
 
 func TestDifferentialBackup(t *testing.T) {
-	// Setup sqlite connection
-	store, err := NewStore()
-	if err != nil {
-		t.Fatal(err)
+	for _, sb := range storeBackends {
+		t.Run(sb.name, func(t *testing.T) { testDifferentialBackup(t, sb.dsn) })
 	}
+}
+
+func testDifferentialBackup(t *testing.T, dsn string) {
+	store := newTestStore(t, dsn)
 	defer store.Close()
 
 	setup(store)
@@ -169,11 +237,13 @@ func TestDifferentialBackup(t *testing.T) {
 }
 
 func TestDifferentialBackupWithChanges(t *testing.T) {
-	// Setup sqlite connection
-	store, err := NewStore()
-	if err != nil {
-		t.Fatal(err)
+	for _, sb := range storeBackends {
+		t.Run(sb.name, func(t *testing.T) { testDifferentialBackupWithChanges(t, sb.dsn) })
 	}
+}
+
+func testDifferentialBackupWithChanges(t *testing.T, dsn string) {
+	store := newTestStore(t, dsn)
 	defer store.Close()
 
 	setup(store)
@@ -232,6 +302,149 @@ func TestDifferentialBackupWithChanges(t *testing.T) {
 	}
 }
 
+// TestDifferentialBackupWithChangesObjectStore is the object-store analogue
+// of testDifferentialBackupWithChanges: only the manifest/block accounting
+// differs, not the diff semantics.
+func TestDifferentialBackupWithChangesObjectStore(t *testing.T) {
+	store := newTestStore(t, "sqlite://backups.db")
+	defer store.Close()
+
+	setup(store)
+	defer cleanup(t)
+
+	cfg := &BackupConfig{
+		Store:           store,
+		DevicePath:      "assets/pg.ext4",
+		OutputFormat:    BackupOutputFormatObjectStore,
+		ObjectStore:     NewFSObjectStore("backups/"),
+		BlockSize:       1048576,
+		BlockBufferSize: 7,
+	}
+
+	b, err := NewBackup(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewBackup(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.vol.DevicePath = "assets/pg_altered.ext4"
+
+	if err := db.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	manifestRC, err := cfg.ObjectStore.Get(manifestKey(db.Record.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manifestRC.Close()
+
+	data, err := io.ReadAll(manifestRC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := decodeManifest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.BlockHashes) != 1 {
+		t.Fatalf("expected 1 block hash in manifest, got %d", len(manifest.BlockHashes))
+	}
+}
+
+// TestIncrementalBackupChain takes a full backup followed by three
+// incrementals, each introducing disjoint changes, then restores at every
+// point in the chain and checks the reconstructed image against the
+// fixture state it was taken from.
+func TestIncrementalBackupChain(t *testing.T) {
+	for _, sb := range storeBackends {
+		t.Run(sb.name, func(t *testing.T) { testIncrementalBackupChain(t, sb.dsn) })
+	}
+}
+
+func testIncrementalBackupChain(t *testing.T, dsn string) {
+	store := newTestStore(t, dsn)
+	defer store.Close()
+
+	setup(store)
+	defer cleanup(t)
+
+	cfg := &BackupConfig{
+		Store:           store,
+		DevicePath:      "assets/pg.ext4",
+		OutputFormat:    BackupOutputFormatFile,
+		OutputDirectory: "backups/",
+		BlockSize:       1048576,
+		BlockBufferSize: 7,
+		Mode:            BackupModeIncremental,
+	}
+
+	fixtures := []string{
+		"assets/pg.ext4",
+		"assets/pg_incr1.ext4",
+		"assets/pg_incr2.ext4",
+		"assets/pg_incr3.ext4",
+	}
+
+	var records []*Backup
+
+	for i, fixture := range fixtures {
+		b, err := NewBackup(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.vol.DevicePath = fixture
+
+		if err := b.Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		wantType := backupTypeIncremental
+		if i == 0 {
+			wantType = backupTypeFull
+		}
+		if b.BackupType() != wantType {
+			t.Errorf("backup %d: expected type %s, got %s", i, wantType, b.BackupType())
+		}
+
+		records = append(records, b)
+	}
+
+	for i, b := range records {
+		restorePath := filepath.Join("restores", fmt.Sprintf("pg-%d.ext4", i))
+
+		restore, err := NewRestore(&RestoreConfig{
+			Store:           store,
+			BackupID:        b.Record.ID,
+			OutputPath:      restorePath,
+			InputFormat:     BackupOutputFormatFile,
+			OutputDirectory: "backups/",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := restore.Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := fileChecksum(fixtures[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		compareChecksum(t, restorePath, want)
+	}
+}
+
 func compareChecksum(t *testing.T, filePath string, expected string) {
 	actual, err := fileChecksum(filePath)
 	if err != nil {
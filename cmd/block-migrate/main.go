@@ -0,0 +1,70 @@
+// Command block-migrate copies backup records, blocks, and positions from
+// one (Store, ObjectStore) pair to another, e.g. to move a host from the
+// sqlite+file layout to a shared bbolt+S3 layout.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/superfly/block-diff"
+)
+
+func main() {
+	var (
+		fromStoreDSN = flag.String("from-store", "", "source store DSN, e.g. sqlite://backups.db")
+		toStoreDSN   = flag.String("to-store", "", "destination store DSN, e.g. bolt://backups.bolt")
+		fromBackend  = flag.String("from-backend", "", "source object store URL, e.g. file://backups")
+		toBackend    = flag.String("to-backend", "", "destination object store URL, e.g. s3://bucket/prefix")
+		parallelism  = flag.Int("parallelism", 4, "concurrent block copy workers")
+		dryRun       = flag.Bool("dry-run", false, "report what would be copied without writing anything")
+	)
+	flag.Parse()
+
+	if *fromStoreDSN == "" || *toStoreDSN == "" || *fromBackend == "" || *toBackend == "" {
+		fmt.Println("usage: block-migrate -from-store DSN -to-store DSN -from-backend URL -to-backend URL [-parallelism N] [-dry-run]")
+		flag.PrintDefaults()
+		log.Fatal("all of -from-store, -to-store, -from-backend, -to-backend are required")
+	}
+
+	from, err := block.NewStoreFromDSN(*fromStoreDSN)
+	if err != nil {
+		log.Fatalf("block-migrate: open source store: %v", err)
+	}
+	defer from.Close()
+
+	to, err := block.NewStoreFromDSN(*toStoreDSN)
+	if err != nil {
+		log.Fatalf("block-migrate: open destination store: %v", err)
+	}
+	defer to.Close()
+	if err := to.SetupDB(); err != nil {
+		log.Fatalf("block-migrate: set up destination store: %v", err)
+	}
+
+	fromObj, err := block.NewObjectStoreFromURL(*fromBackend)
+	if err != nil {
+		log.Fatalf("block-migrate: open source backend: %v", err)
+	}
+
+	toObj, err := block.NewObjectStoreFromURL(*toBackend)
+	if err != nil {
+		log.Fatalf("block-migrate: open destination backend: %v", err)
+	}
+
+	stats, err := block.Migrate(from, to, fromObj, toObj, block.MigrateOptions{
+		Parallelism: *parallelism,
+		DryRun:      *dryRun,
+	})
+	if err != nil {
+		log.Fatalf("block-migrate: %v", err)
+	}
+
+	verb := "copied"
+	if *dryRun {
+		verb = "would copy"
+	}
+	fmt.Printf("%s %d backups, %d blocks (%d already present, %d bytes)\n",
+		verb, stats.BackupsCopied, stats.BlocksCopied, stats.BlocksSkipped, stats.BytesCopied)
+}
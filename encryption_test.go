@@ -0,0 +1,285 @@
+package block
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	testMasterKeyA = []byte("test-master-key-aaaaaaaaaaaaaaaa")
+	testMasterKeyB = []byte("test-master-key-bbbbbbbbbbbbbbbb")
+)
+
+// TestEncryptBlockDeterministic checks that encryptBlock is a pure function
+// of (dataKey, backupID, position, plaintext): encrypting the same block
+// twice under the same fixed data key produces byte-identical ciphertext,
+// the same property compareChecksum relies on for plaintext restores.
+func TestEncryptBlockDeterministic(t *testing.T) {
+	dataKey := bytes.Repeat([]byte{0x42}, dataKeySize)
+	plaintext := []byte("deterministic block payload for testing")
+
+	ct1, err := encryptBlock(dataKey, 7, 3, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct2, err := encryptBlock(dataKey, 7, 3, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ct1, ct2) {
+		t.Fatalf("expected encryptBlock to be deterministic for fixed inputs, got %x and %x", ct1, ct2)
+	}
+
+	// A different origin (backup ID or offset) changes the nonce, and so
+	// must change the ciphertext even for identical plaintext.
+	ct3, err := encryptBlock(dataKey, 7, 4, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(ct1, ct3) {
+		t.Fatal("expected ciphertext to differ when block position differs")
+	}
+
+	decrypted, err := decryptBlock(dataKey, 7, 3, ct1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("expected decryptBlock to recover the original plaintext, got %q", decrypted)
+	}
+}
+
+// TestEncryptedBackupRoundTrip takes an encrypted full backup, checks that
+// the block payloads on disk are not the plaintext, and that Restore (given
+// the same master key) reproduces the original device image.
+func TestEncryptedBackupRoundTrip(t *testing.T) {
+	for _, sb := range storeBackends {
+		t.Run(sb.name, func(t *testing.T) { testEncryptedBackupRoundTrip(t, sb.dsn) })
+	}
+}
+
+func testEncryptedBackupRoundTrip(t *testing.T, dsn string) {
+	store := newTestStore(t, dsn)
+	defer store.Close()
+
+	setup(store)
+	defer cleanup(t)
+
+	cfg := &BackupConfig{
+		Store:           store,
+		DevicePath:      "assets/pg.ext4",
+		OutputFormat:    BackupOutputFormatFile,
+		OutputDirectory: "backups/",
+		BlockSize:       1048576,
+		BlockBufferSize: 10,
+		Encryption:      &EncryptionConfig{MasterKey: testMasterKeyA},
+	}
+
+	b, err := NewBackup(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	positions, err := store.findBlockPositionsByBackup(b.Record.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(positions) == 0 {
+		t.Fatal("expected at least one block position")
+	}
+
+	blockPath := filepath.Join("backups", "blocks", positions[0].Hash+".blk")
+	ciphertext, err := os.ReadFile(blockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := b.vol.readBlockAt(positions[0].Offset, cfg.BlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(ciphertext, plaintext) {
+		t.Fatal("expected the stored block payload to be encrypted, not plaintext")
+	}
+
+	// A differential backup with no changes must still dedup: it records
+	// no new positions' worth of payloads because every hash it sees was
+	// already written (and encrypted) by the first backup.
+	db, err := NewBackup(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	restorePath := filepath.Join("restores", "encrypted-pg.ext4")
+	restore, err := NewRestore(&RestoreConfig{
+		Store:           store,
+		BackupID:        db.Record.ID,
+		OutputPath:      restorePath,
+		InputFormat:     BackupOutputFormatFile,
+		OutputDirectory: "backups/",
+		Encryption:      &EncryptionConfig{MasterKey: testMasterKeyA},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restore.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	compareChecksum(t, restorePath, fullBackupChecksum)
+
+	// Restoring with the wrong master key must fail authentication rather
+	// than emit garbage plaintext.
+	wrongRestore, err := NewRestore(&RestoreConfig{
+		Store:           store,
+		BackupID:        db.Record.ID,
+		OutputPath:      filepath.Join("restores", "encrypted-wrong-key.ext4"),
+		InputFormat:     BackupOutputFormatFile,
+		OutputDirectory: "backups/",
+		Encryption:      &EncryptionConfig{MasterKey: testMasterKeyB},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wrongRestore.Run(); err == nil {
+		t.Fatal("expected restore with the wrong master key to fail")
+	}
+}
+
+// TestEncryptedBackupTamperedBlockFailsAuth corrupts a stored ciphertext
+// block in place and checks that Restore refuses to emit any plaintext for
+// it, failing with an authentication error instead.
+func TestEncryptedBackupTamperedBlockFailsAuth(t *testing.T) {
+	store := newTestStore(t, "sqlite://backups.db")
+	defer store.Close()
+
+	setup(store)
+	defer cleanup(t)
+
+	cfg := &BackupConfig{
+		Store:           store,
+		DevicePath:      "assets/pg.ext4",
+		OutputFormat:    BackupOutputFormatFile,
+		OutputDirectory: "backups/",
+		BlockSize:       1048576,
+		BlockBufferSize: 10,
+		Encryption:      &EncryptionConfig{MasterKey: testMasterKeyA},
+	}
+
+	b, err := NewBackup(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	positions, err := store.findBlockPositionsByBackup(b.Record.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockPath := filepath.Join("backups", "blocks", positions[0].Hash+".blk")
+	data, err := os.ReadFile(blockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(blockPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	restore, err := NewRestore(&RestoreConfig{
+		Store:           store,
+		BackupID:        b.Record.ID,
+		OutputPath:      filepath.Join("restores", "tampered-pg.ext4"),
+		InputFormat:     BackupOutputFormatFile,
+		OutputDirectory: "backups/",
+		Encryption:      &EncryptionConfig{MasterKey: testMasterKeyA},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restore.Run(); err == nil {
+		t.Fatal("expected restore to fail authentication on a tampered block")
+	}
+}
+
+// TestStoreRewrapKeys checks that RewrapKeys re-encrypts a backup's data key
+// under a new master key without touching any block payload: a restore
+// using the new master key succeeds, and the old master key no longer works.
+func TestStoreRewrapKeys(t *testing.T) {
+	for _, sb := range storeBackends {
+		t.Run(sb.name, func(t *testing.T) { testStoreRewrapKeys(t, sb.dsn) })
+	}
+}
+
+func testStoreRewrapKeys(t *testing.T, dsn string) {
+	store := newTestStore(t, dsn)
+	defer store.Close()
+
+	setup(store)
+	defer cleanup(t)
+
+	cfg := &BackupConfig{
+		Store:           store,
+		DevicePath:      "assets/pg.ext4",
+		OutputFormat:    BackupOutputFormatFile,
+		OutputDirectory: "backups/",
+		BlockSize:       1048576,
+		BlockBufferSize: 10,
+		Encryption:      &EncryptionConfig{MasterKey: testMasterKeyA},
+	}
+
+	b, err := NewBackup(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.RewrapKeys(testMasterKeyA, testMasterKeyB); err != nil {
+		t.Fatal(err)
+	}
+
+	restorePath := filepath.Join("restores", "rewrapped-pg.ext4")
+	restore, err := NewRestore(&RestoreConfig{
+		Store:           store,
+		BackupID:        b.Record.ID,
+		OutputPath:      restorePath,
+		InputFormat:     BackupOutputFormatFile,
+		OutputDirectory: "backups/",
+		Encryption:      &EncryptionConfig{MasterKey: testMasterKeyB},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restore.Run(); err != nil {
+		t.Fatal(err)
+	}
+	compareChecksum(t, restorePath, fullBackupChecksum)
+
+	staleRestore, err := NewRestore(&RestoreConfig{
+		Store:           store,
+		BackupID:        b.Record.ID,
+		OutputPath:      filepath.Join("restores", "rewrapped-stale-key.ext4"),
+		InputFormat:     BackupOutputFormatFile,
+		OutputDirectory: "backups/",
+		Encryption:      &EncryptionConfig{MasterKey: testMasterKeyA},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := staleRestore.Run(); err == nil {
+		t.Fatal("expected restore with the pre-rewrap master key to fail")
+	}
+}
@@ -0,0 +1,110 @@
+package block
+
+// incrementalChainLength returns how many incremental backups make up the
+// chain ending at rec, counting rec itself but not the full backup the
+// chain is rooted at. A full backup has length 0.
+func incrementalChainLength(store Store, rec *BackupRecord) (int, error) {
+	length := 0
+	cur := rec
+
+	for cur.BackupType == backupTypeIncremental {
+		length++
+
+		if cur.ParentBackupID == nil {
+			break
+		}
+
+		parent, err := store.GetBackup(*cur.ParentBackupID)
+		if err != nil {
+			return 0, err
+		}
+		cur = parent
+	}
+
+	return length, nil
+}
+
+// chainPositions walks the parent chain starting at backupID back to its
+// root full backup, collecting the most recent hash recorded at each
+// offset. Positions recorded by backupID itself take priority over its
+// ancestors, which take priority over theirs, and so on. It returns the map
+// along with the root (full) backup record.
+func chainPositions(store Store, backupID int64) (map[int]string, *BackupRecord, error) {
+	result := make(map[int]string)
+
+	id := backupID
+	for {
+		rec, err := store.GetBackup(id)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		positions, err := store.findBlockPositionsByBackup(id)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, p := range positions {
+			if _, ok := result[p.Offset]; !ok {
+				result[p.Offset] = p.Hash
+			}
+		}
+
+		if rec.ParentBackupID == nil {
+			return result, rec, nil
+		}
+		id = *rec.ParentBackupID
+	}
+}
+
+// CollapseChain materializes the chain ending at backupID (a full backup
+// and every incremental between it and backupID, inclusive) into a single
+// new full backup, then deletes the collapsed chain members. Block payloads
+// are untouched and keep being shared by hash; only backup/position records
+// are rewritten. This keeps long incremental chains from growing restore
+// time and backup-metadata size without limit.
+func CollapseChain(store Store, backupID int64) (*BackupRecord, error) {
+	positions, _, err := chainPositions(store, backupID)
+	if err != nil {
+		return nil, err
+	}
+
+	tip, err := store.GetBackup(backupID)
+	if err != nil {
+		return nil, err
+	}
+
+	collapsed := &BackupRecord{
+		DevicePath:  tip.DevicePath,
+		BackupType:  backupTypeFull,
+		BlockSize:   tip.BlockSize,
+		TotalBlocks: tip.TotalBlocks,
+	}
+	if err := store.CreateBackup(collapsed); err != nil {
+		return nil, err
+	}
+
+	for offset, hash := range positions {
+		if err := store.RecordPosition(collapsed.ID, offset, hash); err != nil {
+			return nil, err
+		}
+	}
+
+	for id := backupID; ; {
+		rec, err := store.GetBackup(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := store.DeleteBackup(id); err != nil {
+			return nil, err
+		}
+
+		if rec.ParentBackupID == nil {
+			break
+		}
+		id = *rec.ParentBackupID
+	}
+
+	return collapsed, nil
+}
@@ -0,0 +1,174 @@
+package block
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestCollapseChain takes a full backup followed by three incrementals (the
+// same chain testIncrementalBackupChain builds), collapses it, checks that
+// every collapsed chain member is gone from the store, and restores from the
+// new full to confirm it still reproduces the chain tip's content.
+func TestCollapseChain(t *testing.T) {
+	for _, sb := range storeBackends {
+		t.Run(sb.name, func(t *testing.T) { testCollapseChain(t, sb.dsn) })
+	}
+}
+
+func testCollapseChain(t *testing.T, dsn string) {
+	store := newTestStore(t, dsn)
+	defer store.Close()
+
+	setup(store)
+	defer cleanup(t)
+
+	cfg := &BackupConfig{
+		Store:           store,
+		DevicePath:      "assets/pg.ext4",
+		OutputFormat:    BackupOutputFormatFile,
+		OutputDirectory: "backups/",
+		BlockSize:       1048576,
+		BlockBufferSize: 7,
+		Mode:            BackupModeIncremental,
+	}
+
+	fixtures := []string{
+		"assets/pg.ext4",
+		"assets/pg_incr1.ext4",
+		"assets/pg_incr2.ext4",
+		"assets/pg_incr3.ext4",
+	}
+
+	var ids []int64
+	for _, fixture := range fixtures {
+		b, err := NewBackup(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.vol.DevicePath = fixture
+
+		if err := b.Run(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, b.Record.ID)
+	}
+
+	tipID := ids[len(ids)-1]
+
+	collapsed, err := CollapseChain(store, tipID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if collapsed.BackupType != backupTypeFull {
+		t.Errorf("expected collapsed backup type to be full, got %s", collapsed.BackupType)
+	}
+
+	for _, id := range ids {
+		if _, err := store.GetBackup(id); err != ErrBackupNotFound {
+			t.Errorf("expected backup %d to be gone after collapse, got err %v", id, err)
+		}
+	}
+
+	restorePath := filepath.Join("restores", "collapsed-pg.ext4")
+	restore, err := NewRestore(&RestoreConfig{
+		Store:           store,
+		BackupID:        collapsed.ID,
+		OutputPath:      restorePath,
+		InputFormat:     BackupOutputFormatFile,
+		OutputDirectory: "backups/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restore.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := fileChecksum(fixtures[len(fixtures)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareChecksum(t, restorePath, want)
+}
+
+// TestCollapseEncryptedChain checks that collapsing an encrypted incremental
+// chain doesn't disturb decryption: each chain member wrote its own wrapped
+// data key and every block's nonce is bound to its origin backup ID (see
+// encryptBlock), not the backup being restored, so collapsing (which only
+// rewrites backup/position records, never touches blocks or backup_keys)
+// must still restore correctly afterward.
+func TestCollapseEncryptedChain(t *testing.T) {
+	for _, sb := range storeBackends {
+		t.Run(sb.name, func(t *testing.T) { testCollapseEncryptedChain(t, sb.dsn) })
+	}
+}
+
+func testCollapseEncryptedChain(t *testing.T, dsn string) {
+	store := newTestStore(t, dsn)
+	defer store.Close()
+
+	setup(store)
+	defer cleanup(t)
+
+	cfg := &BackupConfig{
+		Store:           store,
+		DevicePath:      "assets/pg.ext4",
+		OutputFormat:    BackupOutputFormatFile,
+		OutputDirectory: "backups/",
+		BlockSize:       1048576,
+		BlockBufferSize: 7,
+		Mode:            BackupModeIncremental,
+		Encryption:      &EncryptionConfig{MasterKey: testMasterKeyA},
+	}
+
+	fixtures := []string{
+		"assets/pg.ext4",
+		"assets/pg_incr1.ext4",
+		"assets/pg_incr2.ext4",
+		"assets/pg_incr3.ext4",
+	}
+
+	var ids []int64
+	for _, fixture := range fixtures {
+		b, err := NewBackup(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.vol.DevicePath = fixture
+
+		if err := b.Run(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, b.Record.ID)
+	}
+
+	tipID := ids[len(ids)-1]
+
+	collapsed, err := CollapseChain(store, tipID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restorePath := filepath.Join("restores", "collapsed-encrypted-pg.ext4")
+	restore, err := NewRestore(&RestoreConfig{
+		Store:           store,
+		BackupID:        collapsed.ID,
+		OutputPath:      restorePath,
+		InputFormat:     BackupOutputFormatFile,
+		OutputDirectory: "backups/",
+		Encryption:      &EncryptionConfig{MasterKey: testMasterKeyA},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restore.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := fileChecksum(fixtures[len(fixtures)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareChecksum(t, restorePath, want)
+}
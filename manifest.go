@@ -0,0 +1,55 @@
+package block
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// BackupManifest is the small JSON document written alongside a backup's
+// blocks when BackupOutputFormat is BackupOutputFormatObjectStore. It's
+// enough to reconstruct the device without consulting the Store: block size,
+// total block count, parent backup ID, and the ordered hash for each
+// position this backup recorded.
+type BackupManifest struct {
+	BackupID       int64    `json:"backup_id"`
+	DevicePath     string   `json:"device_path"`
+	BackupType     string   `json:"backup_type"`
+	BlockSize      int      `json:"block_size"`
+	TotalBlocks    int      `json:"total_blocks"`
+	ParentBackupID *int64   `json:"parent_backup_id,omitempty"`
+	BlockHashes    []string `json:"block_hashes"`
+}
+
+// manifestKey returns the object store key a backup's manifest is written
+// to.
+func manifestKey(backupID int64) string {
+	return fmt.Sprintf("backups/%d/manifest.json", backupID)
+}
+
+// blockKey returns the content-addressed object store key for a block with
+// the given hash, sharded by its first two hex characters so no single
+// directory/prefix holds every block.
+func blockKey(hash string) string {
+	shard := hash
+	if len(shard) > 2 {
+		shard = shard[:2]
+	}
+	return fmt.Sprintf("blocks/%s/%s.blk", shard, hash)
+}
+
+func encodeManifest(m *BackupManifest) (*bytes.Reader, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("block: encode manifest: %w", err)
+	}
+	return bytes.NewReader(data), nil
+}
+
+func decodeManifest(data []byte) (*BackupManifest, error) {
+	var m BackupManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("block: decode manifest: %w", err)
+	}
+	return &m, nil
+}
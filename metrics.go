@@ -0,0 +1,34 @@
+package block
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	storeDBBytesMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "block_store_db_bytes",
+		Help: "Size in bytes of the backup metadata store's database file.",
+	})
+	storeWALBytesMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "block_store_wal_bytes",
+		Help: "Size in bytes of the backup metadata store's write-ahead log, 0 for backends without one.",
+	})
+	storeUniqueBlocksMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "block_store_unique_blocks",
+		Help: "Number of unique block hashes known to the backup metadata store.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(storeDBBytesMetric, storeWALBytesMetric, storeUniqueBlocksMetric)
+}
+
+// ObserveStoreStats updates the block_store_* Prometheus gauges from stats.
+// Callers collect stats periodically, e.g. alongside an AutoVacuum loop:
+//
+//	stats, err := store.Stats()
+//	...
+//	block.ObserveStoreStats(stats)
+func ObserveStoreStats(stats *StoreStats) {
+	storeDBBytesMetric.Set(float64(stats.DBBytes))
+	storeWALBytesMetric.Set(float64(stats.WALBytes))
+	storeUniqueBlocksMetric.Set(float64(stats.UniqueBlocks))
+}
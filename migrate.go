@@ -0,0 +1,304 @@
+package block
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// migrateCursorKey is where Migrate persists the ID of the last backup it
+// fully copied, so a later run can resume instead of starting over.
+const migrateCursorKey = "migrate/cursor"
+
+// MigrateOptions controls a Migrate run.
+type MigrateOptions struct {
+	// Parallelism is the number of block payloads copied concurrently. A
+	// value <= 0 copies one block at a time.
+	Parallelism int
+
+	// DryRun reports the backups/blocks/bytes that would be copied
+	// without writing anything to to or toBackend.
+	DryRun bool
+}
+
+// MigrateStats summarizes what a Migrate run copied (or, for a dry run,
+// would copy).
+type MigrateStats struct {
+	BackupsCopied int
+	BlocksCopied  int
+	BlocksSkipped int
+	BytesCopied   int64
+}
+
+// Migrate copies every backup record, block, and position from (from,
+// fromBackend) to (to, toBackend), following the pattern of Vault's
+// `operator migrate`. It's the canonical way to re-home backups across
+// Store/ObjectStore implementations, e.g. moving the sqlite+file layout
+// used by a single host onto a shared bbolt+S3 layout.
+//
+// Block payloads are addressed with blockKey(hash) in both backends and
+// copied dedup-aware: a hash already present at the destination is
+// skipped. Migrate is resumable across runs: it persists the ID of the
+// last backup it fully copied as migrateCursorKey in toBackend, and picks
+// up after it next time it's called with the same (to, toBackend) pair.
+//
+// Migrate doesn't currently preserve a backup made with BackupConfig.
+// Encryption: it assigns new IDs at the destination, but an encrypted
+// block's ciphertext is bound (via its AES-GCM nonce) to the original
+// backup ID that first wrote it, so it won't decrypt under the remapped
+// origin. Rather than silently stranding that ciphertext, Migrate checks
+// every pending source backup for a wrapped data key and fails outright if
+// it finds one; don't migrate encrypted backups until this is addressed.
+func Migrate(from, to Store, fromBackend, toBackend ObjectStore, opts MigrateOptions) (*MigrateStats, error) {
+	cursor, err := readMigrateCursor(toBackend)
+	if err != nil {
+		return nil, err
+	}
+
+	srcRecords, err := from.ListBackups()
+	if err != nil {
+		return nil, fmt.Errorf("block: migrate: list source backups: %w", err)
+	}
+	sort.Slice(srcRecords, func(i, j int) bool { return srcRecords[i].ID < srcRecords[j].ID })
+
+	dstRecords, err := to.ListBackups()
+	if err != nil {
+		return nil, fmt.Errorf("block: migrate: list destination backups: %w", err)
+	}
+
+	var pending []*BackupRecord
+	for _, rec := range srcRecords {
+		if rec.ID > cursor {
+			pending = append(pending, rec)
+		}
+	}
+
+	// Migrate never reorders or skips backups, so the nth already-migrated
+	// source record corresponds to the nth destination record: rebuild the
+	// old -> new ID mapping for everything a prior run already copied by
+	// zipping the two lists instead of persisting the whole map.
+	already := len(srcRecords) - len(pending)
+	if already > len(dstRecords) {
+		return nil, fmt.Errorf("block: migrate: destination has %d backups, expected at least %d already migrated", len(dstRecords), already)
+	}
+
+	idMap := make(map[int64]int64, len(srcRecords))
+	for i := 0; i < already; i++ {
+		idMap[srcRecords[i].ID] = dstRecords[i].ID
+	}
+
+	stats := &MigrateStats{}
+	dryRunCopied := make(map[string]bool)
+
+	for _, rec := range pending {
+		if _, err := from.BackupKey(rec.ID); err == nil {
+			return nil, fmt.Errorf("block: migrate: backup %d was made with BackupConfig.Encryption, which Migrate can't preserve (see Migrate's doc comment); refusing to strand its ciphertext at the destination", rec.ID)
+		} else if err != ErrKeyNotFound {
+			return nil, fmt.Errorf("block: migrate: check encryption for backup %d: %w", rec.ID, err)
+		}
+
+		positions, err := from.findBlockPositionsByBackup(rec.ID)
+		if err != nil {
+			return nil, fmt.Errorf("block: migrate: list positions for backup %d: %w", rec.ID, err)
+		}
+
+		// A dry run copies nothing, so a hash counted for an earlier backup
+		// of this run is counted as skipped here, as a real run would.
+		toCopy := positions
+		if opts.DryRun {
+			toCopy = nil
+			for _, hash := range uniqueHashes(positions) {
+				if dryRunCopied[hash] {
+					stats.BlocksSkipped++
+					continue
+				}
+				dryRunCopied[hash] = true
+				toCopy = append(toCopy, BlockPosition{Hash: hash})
+			}
+		}
+
+		copied, skipped, bytesCopied, err := migrateBlocks(fromBackend, toBackend, toCopy, opts)
+		if err != nil {
+			return nil, fmt.Errorf("block: migrate: backup %d: %w", rec.ID, err)
+		}
+		stats.BackupsCopied++
+		stats.BlocksCopied += copied
+		stats.BlocksSkipped += skipped
+		stats.BytesCopied += bytesCopied
+
+		if opts.DryRun {
+			continue
+		}
+
+		newRec := &BackupRecord{
+			DevicePath:  rec.DevicePath,
+			BackupType:  rec.BackupType,
+			BlockSize:   rec.BlockSize,
+			TotalBlocks: rec.TotalBlocks,
+		}
+		if rec.ParentBackupID != nil {
+			newParentID, ok := idMap[*rec.ParentBackupID]
+			if !ok {
+				return nil, fmt.Errorf("block: migrate: backup %d references parent %d that hasn't been migrated yet", rec.ID, *rec.ParentBackupID)
+			}
+			newRec.ParentBackupID = &newParentID
+		}
+
+		if err := to.CreateBackup(newRec); err != nil {
+			return nil, fmt.Errorf("block: migrate: create backup for source %d: %w", rec.ID, err)
+		}
+		idMap[rec.ID] = newRec.ID
+
+		for _, pos := range positions {
+			if _, err := to.RecordBlock(pos.Hash, newRec.ID, pos.Offset); err != nil {
+				return nil, fmt.Errorf("block: migrate: record block %s: %w", pos.Hash, err)
+			}
+			if err := to.RecordPosition(newRec.ID, pos.Offset, pos.Hash); err != nil {
+				return nil, fmt.Errorf("block: migrate: record position %d: %w", pos.Offset, err)
+			}
+		}
+
+		if err := writeMigrateCursor(toBackend, rec.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
+
+// migrateBlocks copies the unique block hashes referenced by positions from
+// fromBackend to toBackend, up to opts.Parallelism at a time.
+func migrateBlocks(fromBackend, toBackend ObjectStore, positions []BlockPosition, opts MigrateOptions) (copied, skipped int, bytesCopied int64, err error) {
+	bufSize := opts.Parallelism
+	if bufSize <= 0 {
+		bufSize = 1
+	}
+	sem := make(chan struct{}, bufSize)
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+
+	for _, hash := range uniqueHashes(positions) {
+		hash := hash
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			size, wasCopied, copyErr := migrateBlock(fromBackend, toBackend, hash, opts.DryRun)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if copyErr != nil {
+				if firstErr == nil {
+					firstErr = copyErr
+				}
+				return
+			}
+			if wasCopied {
+				copied++
+				bytesCopied += size
+			} else {
+				skipped++
+			}
+		}()
+	}
+
+	wg.Wait()
+	return copied, skipped, bytesCopied, firstErr
+}
+
+// migrateBlock copies a single block hash from fromBackend to toBackend,
+// skipping the copy if it's already present at the destination.
+func migrateBlock(fromBackend, toBackend ObjectStore, hash string, dryRun bool) (size int64, copied bool, err error) {
+	key := blockKey(hash)
+
+	if size, exists, err := toBackend.Stat(key); err != nil {
+		return 0, false, fmt.Errorf("stat destination block %s: %w", hash, err)
+	} else if exists {
+		return size, false, nil
+	}
+
+	size, exists, err := fromBackend.Stat(key)
+	if err != nil {
+		return 0, false, fmt.Errorf("stat source block %s: %w", hash, err)
+	}
+	if !exists {
+		return 0, false, fmt.Errorf("source missing block %s", hash)
+	}
+
+	if dryRun {
+		return size, true, nil
+	}
+
+	rc, err := fromBackend.Get(key)
+	if err != nil {
+		return 0, false, fmt.Errorf("read source block %s: %w", hash, err)
+	}
+	defer rc.Close()
+
+	if err := toBackend.Put(key, rc); err != nil {
+		return 0, false, fmt.Errorf("write destination block %s: %w", hash, err)
+	}
+
+	return size, true, nil
+}
+
+func uniqueHashes(positions []BlockPosition) []string {
+	seen := make(map[string]bool, len(positions))
+	hashes := make([]string, 0, len(positions))
+
+	for _, pos := range positions {
+		if seen[pos.Hash] {
+			continue
+		}
+		seen[pos.Hash] = true
+		hashes = append(hashes, pos.Hash)
+	}
+
+	return hashes
+}
+
+func readMigrateCursor(toBackend ObjectStore) (int64, error) {
+	_, exists, err := toBackend.Stat(migrateCursorKey)
+	if err != nil {
+		return 0, fmt.Errorf("block: migrate: stat cursor: %w", err)
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	rc, err := toBackend.Get(migrateCursorKey)
+	if err != nil {
+		return 0, fmt.Errorf("block: migrate: read cursor: %w", err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return 0, fmt.Errorf("block: migrate: read cursor: %w", err)
+	}
+
+	cursor, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("block: migrate: parse cursor: %w", err)
+	}
+
+	return cursor, nil
+}
+
+func writeMigrateCursor(toBackend ObjectStore, id int64) error {
+	if err := toBackend.Put(migrateCursorKey, strings.NewReader(strconv.FormatInt(id, 10))); err != nil {
+		return fmt.Errorf("block: migrate: write cursor: %w", err)
+	}
+	return nil
+}
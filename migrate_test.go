@@ -0,0 +1,109 @@
+package block
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMigrate takes a full backup on a sqlite+FS-object-store pair, migrates
+// it to a fresh bolt+FS-object-store pair, and checks that a restore from
+// the destination reproduces the same device image.
+func TestMigrate(t *testing.T) {
+	fromStore := newTestStore(t, "sqlite://backups.db")
+	defer fromStore.Close()
+
+	setup(fromStore)
+	defer cleanup(t)
+
+	fromBackend := NewFSObjectStore("backups/")
+
+	cfg := &BackupConfig{
+		Store:           fromStore,
+		DevicePath:      "assets/pg.ext4",
+		OutputFormat:    BackupOutputFormatObjectStore,
+		ObjectStore:     fromBackend,
+		BlockSize:       1048576,
+		BlockBufferSize: 10,
+	}
+
+	b, err := NewBackup(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	toStore, err := NewBoltStore("migrated.bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer toStore.Close()
+	defer func() {
+		if err := os.Remove("migrated.bolt"); err != nil {
+			t.Log(err)
+		}
+	}()
+
+	if err := toStore.SetupDB(); err != nil {
+		t.Fatal(err)
+	}
+
+	toBackend := NewFSObjectStore("migrated-backups/")
+	defer func() {
+		if err := os.RemoveAll("migrated-backups/"); err != nil {
+			t.Log(err)
+		}
+	}()
+
+	stats, err := Migrate(fromStore, toStore, fromBackend, toBackend, MigrateOptions{Parallelism: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.BackupsCopied != 1 {
+		t.Fatalf("expected 1 backup copied, got %d", stats.BackupsCopied)
+	}
+	if stats.BlocksCopied != 37 {
+		t.Fatalf("expected 37 blocks copied, got %d", stats.BlocksCopied)
+	}
+	if stats.BlocksSkipped != 0 {
+		t.Fatalf("expected 0 blocks skipped, got %d", stats.BlocksSkipped)
+	}
+
+	dstRecords, err := toStore.ListBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dstRecords) != 1 {
+		t.Fatalf("expected 1 destination backup, got %d", len(dstRecords))
+	}
+
+	restorePath := filepath.Join("restores", "migrated-pg.ext4")
+	restore, err := NewRestore(&RestoreConfig{
+		Store:       toStore,
+		BackupID:    dstRecords[0].ID,
+		OutputPath:  restorePath,
+		InputFormat: BackupOutputFormatObjectStore,
+		ObjectStore: toBackend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restore.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	compareChecksum(t, restorePath, fullBackupChecksum)
+
+	// Re-running Migrate against the same destination copies nothing new.
+	stats, err = Migrate(fromStore, toStore, fromBackend, toBackend, MigrateOptions{Parallelism: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.BackupsCopied != 0 {
+		t.Fatalf("expected resumed migrate to copy 0 backups, got %d", stats.BackupsCopied)
+	}
+}
+
@@ -0,0 +1,44 @@
+package block
+
+import (
+	"fmt"
+	"io"
+)
+
+// ObjectStore is the minimal key/value blob interface the object-store
+// backup path needs. Keys are "/"-separated, e.g.
+// "blocks/ab/ab34...blk" or "backups/12/manifest.json".
+type ObjectStore interface {
+	// Put writes r to key, replacing any existing value.
+	Put(key string, r io.Reader) error
+
+	// Get opens key for reading. The caller must close the returned
+	// ReadCloser.
+	Get(key string) (io.ReadCloser, error)
+
+	// Stat reports whether key exists and, if so, its size in bytes.
+	Stat(key string) (size int64, exists bool, err error)
+
+	// List returns every key with the given prefix.
+	List(prefix string) ([]string, error)
+}
+
+// NewObjectStoreFromURL opens an ObjectStore selected by url's scheme.
+//
+//	file:///path/to/dir
+//	s3://bucket/prefix
+func NewObjectStoreFromURL(url string) (ObjectStore, error) {
+	scheme, rest, err := splitDSN(url)
+	if err != nil {
+		return nil, err
+	}
+
+	switch scheme {
+	case "file":
+		return NewFSObjectStore(rest), nil
+	case "s3":
+		return NewS3ObjectStore(rest)
+	default:
+		return nil, fmt.Errorf("block: unsupported object store URL scheme %q", scheme)
+	}
+}
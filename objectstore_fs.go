@@ -0,0 +1,92 @@
+package block
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FSObjectStore is an ObjectStore backed by a local directory, laid out
+// exactly like the key space: Put("blocks/ab/abcd.blk", ...) writes
+// <root>/blocks/ab/abcd.blk. It's primarily useful for tests, and reuses the
+// same "backups/" tree BackupOutputFormatFile writes under.
+type FSObjectStore struct {
+	root string
+}
+
+// NewFSObjectStore returns an ObjectStore rooted at dir.
+func NewFSObjectStore(dir string) *FSObjectStore {
+	return &FSObjectStore{root: dir}
+}
+
+func (s *FSObjectStore) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+func (s *FSObjectStore) Put(key string, r io.Reader) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("block: fs object store mkdir: %w", err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("block: fs object store create %s: %w", key, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("block: fs object store write %s: %w", key, err)
+	}
+
+	return nil
+}
+
+func (s *FSObjectStore) Get(key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		return nil, fmt.Errorf("block: fs object store get %s: %w", key, err)
+	}
+	return f, nil
+}
+
+func (s *FSObjectStore) Stat(key string) (int64, bool, error) {
+	info, err := os.Stat(s.path(key))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, fmt.Errorf("block: fs object store stat %s: %w", key, err)
+	}
+
+	return info.Size(), true, nil
+}
+
+func (s *FSObjectStore) List(prefix string) ([]string, error) {
+	root := s.path(prefix)
+
+	var keys []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("block: fs object store list %s: %w", prefix, err)
+	}
+
+	return keys, nil
+}
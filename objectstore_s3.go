@@ -0,0 +1,115 @@
+package block
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3ObjectStore is an ObjectStore backed by an S3 (or S3-compatible) bucket.
+// Keys are stored under Prefix, joined with "/".
+type S3ObjectStore struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// NewS3ObjectStore parses a "bucket[/prefix]" location (the part of an
+// s3://bucket/prefix URL after the scheme) and opens a client using the
+// default AWS credential chain.
+func NewS3ObjectStore(location string) (*S3ObjectStore, error) {
+	bucket, prefix, _ := strings.Cut(location, "/")
+
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("block: load aws config: %w", err)
+	}
+
+	return &S3ObjectStore{
+		client: s3.NewFromConfig(cfg),
+		bucket: bucket,
+		prefix: prefix,
+	}, nil
+}
+
+func (s *S3ObjectStore) key(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+func (s *S3ObjectStore) Put(key string, r io.Reader) error {
+	_, err := s.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+		Body:   r,
+	})
+	if err != nil {
+		return fmt.Errorf("block: s3 put %s: %w", key, err)
+	}
+
+	return nil
+}
+
+func (s *S3ObjectStore) Get(key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("block: s3 get %s: %w", key, err)
+	}
+
+	return out.Body, nil
+}
+
+func (s *S3ObjectStore) Stat(key string) (int64, bool, error) {
+	out, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("block: s3 stat %s: %w", key, err)
+	}
+
+	size := int64(0)
+	if out.ContentLength != nil {
+		size = *out.ContentLength
+	}
+
+	return size, true, nil
+}
+
+func (s *S3ObjectStore) List(prefix string) ([]string, error) {
+	var keys []string
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.key(prefix)),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("block: s3 list %s: %w", prefix, err)
+		}
+
+		for _, obj := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(aws.ToString(obj.Key), s.prefix+"/"))
+		}
+	}
+
+	return keys, nil
+}
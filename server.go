@@ -0,0 +1,301 @@
+package block
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ServerConfig configures a Server.
+type ServerConfig struct {
+	// Store is opened read-only by convention: the server never calls
+	// CreateBackup, RecordBlock, RecordPosition, or DeleteBackup.
+	Store Store
+
+	// InputFormat selects where block payloads are read from; it must
+	// match the BackupOutputFormat the backups were written with.
+	InputFormat BackupOutputFormat
+
+	// OutputDirectory is where block payloads are read from when
+	// InputFormat is BackupOutputFormatFile.
+	OutputDirectory string
+
+	// ObjectStore is where block payloads are read from when
+	// InputFormat is BackupOutputFormatObjectStore.
+	ObjectStore ObjectStore
+
+	// AuthToken, if set, requires every request to carry a matching
+	// "Authorization: Bearer <AuthToken>" header.
+	AuthToken string
+
+	// Encryption, if set, decrypts every block payload served over HTTP.
+	// It must match the Encryption used (if any) when the backups were
+	// written.
+	Encryption *EncryptionConfig
+}
+
+// Server exposes a Store and its backing block payloads over HTTP:
+//
+//	GET /backups                      list backups
+//	GET /backups/{id}                 backup metadata + parent chain
+//	GET /backups/{id}/volume          stream the reconstructed device image
+//	GET /backups/{id}/blocks/{offset} a single block's payload
+//
+// /backups/{id}/volume supports Range requests, so a client can
+// `curl --range | dd` or mount it over a loopback device without
+// materializing the whole image to disk first.
+type Server struct {
+	cfg *ServerConfig
+
+	dec *blockDecrypter
+}
+
+// NewServer validates cfg and prepares a Server. Nothing is read until it's
+// used as an http.Handler.
+func NewServer(cfg *ServerConfig) (*Server, error) {
+	dec, err := newBlockDecrypter(cfg.Store, cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{cfg: cfg, dec: dec}, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/backups"), "/")
+	if path == "" {
+		s.listBackups(w, r)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		s.getBackup(w, r, id)
+	case len(parts) == 2 && parts[1] == "volume":
+		s.streamVolume(w, r, id)
+	case len(parts) == 3 && parts[1] == "blocks":
+		offset, err := strconv.Atoi(parts[2])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		s.getBlock(w, r, id, offset)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.cfg.AuthToken == "" {
+		return true
+	}
+	return r.Header.Get("Authorization") == "Bearer "+s.cfg.AuthToken
+}
+
+func (s *Server) listBackups(w http.ResponseWriter, r *http.Request) {
+	records, err := s.cfg.Store.ListBackups()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, records)
+}
+
+// backupDetail is the /backups/{id} response body: the backup record plus
+// the chain of ancestor IDs it was diffed against, oldest first.
+type backupDetail struct {
+	*BackupRecord
+	ParentChain []int64 `json:"parent_chain,omitempty"`
+}
+
+func (s *Server) getBackup(w http.ResponseWriter, r *http.Request, id int64) {
+	rec, err := s.cfg.Store.GetBackup(id)
+	if err == ErrBackupNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var chain []int64
+	for cur := rec; cur.ParentBackupID != nil; {
+		parent, err := s.cfg.Store.GetBackup(*cur.ParentBackupID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		chain = append([]int64{parent.ID}, chain...)
+		cur = parent
+	}
+
+	writeJSON(w, &backupDetail{BackupRecord: rec, ParentChain: chain})
+}
+
+func (s *Server) streamVolume(w http.ResponseWriter, r *http.Request, id int64) {
+	rec, err := s.cfg.Store.GetBackup(id)
+	if err == ErrBackupNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	positions, _, err := chainPositions(s.cfg.Store, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	size := int64(rec.TotalBlocks) * int64(rec.BlockSize)
+	start, end, status := parseRange(r.Header.Get("Range"), size)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Accept-Ranges", "bytes")
+	if status == http.StatusPartialContent {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(status)
+
+	firstBlock := int(start / int64(rec.BlockSize))
+	lastBlock := int(end / int64(rec.BlockSize))
+
+	for offset := firstBlock; offset <= lastBlock; offset++ {
+		data, err := s.readBlockAtOffset(positions, offset, rec.BlockSize)
+		if err != nil {
+			return
+		}
+
+		blockStart := int64(offset) * int64(rec.BlockSize)
+		lo := int64(0)
+		if blockStart < start {
+			lo = start - blockStart
+		}
+		hi := int64(len(data))
+		if blockStart+hi > end+1 {
+			hi = end + 1 - blockStart
+		}
+
+		if _, err := w.Write(data[lo:hi]); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) getBlock(w http.ResponseWriter, r *http.Request, id int64, offset int) {
+	positions, _, err := chainPositions(s.cfg.Store, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	hash, ok := positions[offset]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := s.readBlock(hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+// readBlockAtOffset reads the block at offset, or a zero-filled block if
+// offset was never written to (a hole in a sparse backup chain).
+func (s *Server) readBlockAtOffset(positions map[int]string, offset, blockSize int) ([]byte, error) {
+	hash, ok := positions[offset]
+	if !ok {
+		return make([]byte, blockSize), nil
+	}
+	return s.readBlock(hash)
+}
+
+func (s *Server) readBlock(hash string) ([]byte, error) {
+	data, err := s.readBlockPayload(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.dec != nil {
+		return s.dec.decrypt(hash, data)
+	}
+	return data, nil
+}
+
+func (s *Server) readBlockPayload(hash string) ([]byte, error) {
+	switch s.cfg.InputFormat {
+	case BackupOutputFormatFile:
+		return readFileBlock(s.cfg.OutputDirectory, hash)
+	case BackupOutputFormatObjectStore:
+		rc, err := s.cfg.ObjectStore.Get(blockKey(hash))
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	default:
+		return nil, fmt.Errorf("block: unsupported BackupOutputFormat %d", s.cfg.InputFormat)
+	}
+}
+
+// parseRange parses a single-range "Range: bytes=start-end" header against
+// a resource of the given size, returning an inclusive [start, end] byte
+// range and the HTTP status to respond with. An absent or unparsable header
+// returns the full range with 200 OK.
+func parseRange(header string, size int64) (start, end int64, status int) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return 0, size - 1, http.StatusOK
+	}
+
+	spec := strings.SplitN(strings.TrimPrefix(header, prefix), "-", 2)
+	if len(spec) != 2 {
+		return 0, size - 1, http.StatusOK
+	}
+
+	start, err1 := strconv.ParseInt(spec[0], 10, 64)
+	end, err2 := strconv.ParseInt(spec[1], 10, 64)
+
+	switch {
+	case err1 == nil && err2 == nil:
+		// bytes=start-end
+	case err1 == nil && err2 != nil:
+		end = size - 1
+	default:
+		return 0, size - 1, http.StatusOK
+	}
+
+	if start < 0 || start > end || end >= size {
+		return 0, size - 1, http.StatusOK
+	}
+
+	return start, end, http.StatusPartialContent
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
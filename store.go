@@ -0,0 +1,266 @@
+package block
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBlockNotFound is returned when a block hash has no record in the
+// Store.
+var ErrBlockNotFound = errors.New("block: block not found")
+
+// ErrBackupNotFound is returned when a backup ID has no matching record.
+var ErrBackupNotFound = errors.New("block: backup not found")
+
+// ErrKeyNotFound is returned when a backup has no wrapped data key, e.g.
+// because it predates BackupConfig.Encryption or was never encrypted.
+var ErrKeyNotFound = errors.New("block: key not found")
+
+// BackupRecord describes a single backup run.
+type BackupRecord struct {
+	ID          int64  `json:"id"`
+	DevicePath  string `json:"device_path"`
+	BackupType  string `json:"backup_type"`
+	BlockSize   int    `json:"block_size"`
+	TotalBlocks int    `json:"total_blocks"`
+
+	// CreatedAt is when the backup was recorded. Set by the Store on
+	// CreateBackup; callers don't need to populate it.
+	CreatedAt time.Time `json:"created_at"`
+
+	// ParentBackupID is the backup this one was diffed against: the most
+	// recent full backup for a differential, or the immediately
+	// preceding backup (full or incremental) for an incremental. It's
+	// nil for full backups.
+	ParentBackupID *int64 `json:"parent_backup_id,omitempty"`
+}
+
+// BlockPosition maps an offset within a backup's device to the hash of the
+// block stored at that offset.
+type BlockPosition struct {
+	Offset int
+	Hash   string
+}
+
+// Store persists backup metadata: backup records, the set of unique block
+// hashes seen across all backups, and the position -> hash mapping for each
+// backup. Implementations are free to choose their own physical layout; the
+// sqlite-backed Store and the bbolt-backed Store are both safe for use by a
+// single Backup/restore run at a time.
+type Store interface {
+	// SetupDB creates whatever tables/buckets the implementation needs if
+	// they don't already exist.
+	SetupDB() error
+
+	// Close releases any resources (file handles, connections) held by
+	// the store.
+	Close() error
+
+	// CreateBackup inserts a new backup record and returns it with its ID
+	// populated.
+	CreateBackup(rec *BackupRecord) error
+
+	// RecordBlock upserts a block hash into the set of known blocks,
+	// returning whether it already existed. originBackupID/originOffset
+	// are recorded only the first time a hash is seen; BlockOrigin
+	// returns them later to decrypt a deduped block with its true
+	// writer's key.
+	RecordBlock(hash string, originBackupID int64, originOffset int) (existed bool, err error)
+
+	// BlockOrigin returns the backup and offset whose write first stored
+	// hash's payload. A later backup that merely references the same
+	// hash (dedup) never rewrites it, so decrypting it requires the
+	// original writer's per-backup key and nonce.
+	BlockOrigin(hash string) (backupID int64, offset int, err error)
+
+	// RecordPosition records that, within backupID, the block at offset
+	// has the given hash.
+	RecordPosition(backupID int64, offset int, hash string) error
+
+	// findBlockPositionsByBackup returns every position recorded for
+	// backupID, ordered by offset.
+	findBlockPositionsByBackup(backupID int64) ([]BlockPosition, error)
+
+	// TotalBlocks returns the number of unique block hashes known to the
+	// store across all backups.
+	TotalBlocks() (int, error)
+
+	// LatestBackup returns the most recent backup record matching
+	// backupType for devicePath, or ErrBackupNotFound if none exists.
+	LatestBackup(devicePath, backupType string) (*BackupRecord, error)
+
+	// LatestBackupAnyType returns the most recent backup record for
+	// devicePath regardless of type, or ErrBackupNotFound if none
+	// exists. Used to find the parent of a new incremental backup.
+	LatestBackupAnyType(devicePath string) (*BackupRecord, error)
+
+	// GetBackup returns the backup record with the given ID, or
+	// ErrBackupNotFound if none exists.
+	GetBackup(id int64) (*BackupRecord, error)
+
+	// DeleteBackup removes a backup record and its positions. It does
+	// not touch the shared blocks table or block payloads, since other
+	// backups may reference the same hashes.
+	DeleteBackup(id int64) error
+
+	// ListBackups returns every backup record, ordered by ID.
+	ListBackups() ([]*BackupRecord, error)
+
+	// Salt returns the store's persistent random salt used to derive
+	// encryption keys, generating one on first use.
+	Salt() ([]byte, error)
+
+	// SetBackupKey persists backupID's wrapped per-backup data key.
+	SetBackupKey(backupID int64, wrapped []byte) error
+
+	// BackupKey returns backupID's wrapped data key, or ErrKeyNotFound if
+	// the backup wasn't encrypted.
+	BackupKey(backupID int64) ([]byte, error)
+
+	// RewrapKeys re-encrypts every backup's wrapped data key from
+	// oldMasterKey to newMasterKey, without touching any block payload.
+	// Use it to rotate EncryptionConfig.MasterKey.
+	RewrapKeys(oldMasterKey, newMasterKey []byte) error
+
+	// Vacuum reclaims space freed by deleted backups and positions. For
+	// the sqlite backend this checkpoints and truncates the WAL, then
+	// runs VACUUM; for the bolt backend it compacts the database into a
+	// fresh file. It can be slow on a large store and should be run from
+	// a background goroutine (see AutoVacuum) rather than inline with a
+	// backup or restore.
+	Vacuum(ctx context.Context) error
+
+	// Stats reports the store's on-disk size and block/position
+	// accounting, e.g. for periodic Prometheus metrics or AutoVacuum's
+	// freelist check.
+	Stats() (*StoreStats, error)
+}
+
+// StoreStats summarizes a Store's on-disk footprint and the backup data it
+// holds.
+type StoreStats struct {
+	// DBBytes is the size in bytes of the store's database file.
+	DBBytes int64
+
+	// FreelistPages is the number of pages the backend has marked free
+	// but not yet reclaimed. It grows as backups are deleted and shrinks
+	// after a successful Vacuum.
+	FreelistPages int64
+
+	// WALBytes is the size in bytes of the store's write-ahead log, or 0
+	// for backends that don't use one.
+	WALBytes int64
+
+	// UniqueBlocks is the number of unique block hashes known to the
+	// store across all backups.
+	UniqueBlocks int64
+
+	// TotalPositions is the number of offset -> hash positions recorded
+	// across all backups.
+	TotalPositions int64
+}
+
+// StoreConfig holds the DSN a Store is opened from (in the form
+// NewStoreFromDSN accepts), and controls how AutoVacuum maintains it
+// afterwards.
+type StoreConfig struct {
+	// DSN selects the backend and its location, e.g. "sqlite://backups.db"
+	// or "bolt://backups.bolt". An empty DSN defaults to
+	// "sqlite://backups.db" for backwards compatibility with NewStore.
+	DSN string
+
+	// AutoVacuumInterval is how often AutoVacuum checks whether the store
+	// needs vacuuming. A value <= 0 disables AutoVacuum.
+	AutoVacuumInterval time.Duration
+
+	// AutoVacuumMinFreePages is the minimum number of freelist pages
+	// (per Store.Stats) required before AutoVacuum bothers running
+	// Vacuum.
+	AutoVacuumMinFreePages int64
+}
+
+// AutoVacuum periodically vacuums store on cfg.AutoVacuumInterval, skipping
+// any tick where the store's freelist has fewer than
+// cfg.AutoVacuumMinFreePages pages to reclaim. It blocks until ctx is
+// canceled, so callers run it in its own goroutine:
+//
+//	go block.AutoVacuum(ctx, store, cfg)
+func AutoVacuum(ctx context.Context, store Store, cfg StoreConfig) error {
+	if cfg.AutoVacuumInterval <= 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(cfg.AutoVacuumInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			stats, err := store.Stats()
+			if err != nil {
+				return fmt.Errorf("block: autovacuum: %w", err)
+			}
+
+			if stats.FreelistPages < cfg.AutoVacuumMinFreePages {
+				continue
+			}
+
+			if err := store.Vacuum(ctx); err != nil {
+				return fmt.Errorf("block: autovacuum: %w", err)
+			}
+		}
+	}
+}
+
+// NewStore opens the default sqlite-backed Store at backups.db, preserving
+// the historical zero-config entry point.
+func NewStore() (Store, error) {
+	return NewSQLiteStore("backups.db")
+}
+
+// NewStoreFromDSN opens a Store backend selected by dsn's scheme.
+//
+//	sqlite://path/to/file.db
+//	bolt://path/to/file.bolt
+func NewStoreFromDSN(dsn string) (Store, error) {
+	scheme, path, err := splitDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch scheme {
+	case "sqlite":
+		return NewSQLiteStore(path)
+	case "bolt":
+		return NewBoltStore(path)
+	default:
+		return nil, fmt.Errorf("block: unsupported store DSN scheme %q", scheme)
+	}
+}
+
+func splitDSN(dsn string) (scheme, path string, err error) {
+	if dsn == "" {
+		return "sqlite", "backups.db", nil
+	}
+
+	const sep = "://"
+	idx := indexOf(dsn, sep)
+	if idx < 0 {
+		return "", "", fmt.Errorf("block: invalid store DSN %q, expected scheme://path", dsn)
+	}
+
+	return dsn[:idx], dsn[idx+len(sep):], nil
+}
+
+func indexOf(s, sub string) int {
+	for i := 0; i+len(sub) <= len(s); i++ {
+		if s[i:i+len(sub)] == sub {
+			return i
+		}
+	}
+	return -1
+}
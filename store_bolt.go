@@ -0,0 +1,527 @@
+package block
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltBucketBackups   = []byte("backups")
+	boltBucketBlocks    = []byte("blocks")
+	boltBucketPositions = []byte("positions")
+	boltBucketMeta      = []byte("meta")
+	boltBucketKeys      = []byte("backup_keys")
+)
+
+// boltMetaSaltKey is the meta-bucket key holding the persistent encryption
+// salt.
+var boltMetaSaltKey = []byte("salt")
+
+// BoltStore is a Store implementation backed by a single embedded
+// go.etcd.io/bbolt database file. Unlike SQLiteStore it requires no cgo.
+//
+// Layout:
+//
+//	backups/<id>              -> BackupRecord (see encodeBackupRecord)
+//	blocks/<hash>              -> origin backup ID and offset (see encodeBlockOrigin)
+//	positions/<backup_id>/<offset> -> sub-bucket per backup_id, offset -> hash
+type BoltStore struct {
+	path string
+
+	// mu guards db itself (not bbolt's own internal locking): Vacuum
+	// closes and replaces db with a freshly compacted handle, and every
+	// other method must not read a handle Vacuum is in the middle of
+	// swapping out from under it.
+	mu sync.RWMutex
+	db *bolt.DB
+}
+
+// NewBoltStore opens (without creating buckets) the bbolt database at path.
+// Call SetupDB before using the returned Store.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("block: open bolt store: %w", err)
+	}
+
+	return &BoltStore{path: path, db: db}, nil
+}
+
+// handle returns the current *bolt.DB, safe to call concurrently with
+// Vacuum.
+func (s *BoltStore) handle() *bolt.DB {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db
+}
+
+func (s *BoltStore) SetupDB() error {
+	return s.handle().Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltBucketBackups, boltBucketBlocks, boltBucketPositions, boltBucketMeta, boltBucketKeys} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("block: create bucket %s: %w", name, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Close() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.Close()
+}
+
+func (s *BoltStore) CreateBackup(rec *BackupRecord) error {
+	return s.handle().Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(boltBucketBackups)
+
+		id, err := bkt.NextSequence()
+		if err != nil {
+			return fmt.Errorf("block: next backup id: %w", err)
+		}
+		rec.ID = int64(id)
+		rec.CreatedAt = time.Now().UTC()
+
+		return bkt.Put(boltBackupKey(rec.ID), encodeBackupRecord(rec))
+	})
+}
+
+func (s *BoltStore) RecordBlock(hash string, originBackupID int64, originOffset int) (bool, error) {
+	var existed bool
+
+	err := s.handle().Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(boltBucketBlocks)
+		existed = bkt.Get([]byte(hash)) != nil
+		if existed {
+			return nil
+		}
+		return bkt.Put([]byte(hash), encodeBlockOrigin(originBackupID, originOffset))
+	})
+
+	return existed, err
+}
+
+// BlockOrigin returns the backup and offset that first recorded hash.
+func (s *BoltStore) BlockOrigin(hash string) (int64, int, error) {
+	var (
+		backupID int64
+		offset   int
+		found    bool
+	)
+
+	err := s.handle().View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucketBlocks).Get([]byte(hash))
+		if v == nil {
+			return nil
+		}
+		found = true
+		backupID, offset = decodeBlockOrigin(v)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if !found {
+		return 0, 0, ErrBlockNotFound
+	}
+
+	return backupID, offset, nil
+}
+
+func (s *BoltStore) RecordPosition(backupID int64, offset int, hash string) error {
+	return s.handle().Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(boltBucketPositions)
+		sub, err := root.CreateBucketIfNotExists(boltBackupKey(backupID))
+		if err != nil {
+			return fmt.Errorf("block: create position bucket: %w", err)
+		}
+		return sub.Put(boltOffsetKey(offset), []byte(hash))
+	})
+}
+
+func (s *BoltStore) findBlockPositionsByBackup(backupID int64) ([]BlockPosition, error) {
+	var positions []BlockPosition
+
+	err := s.handle().View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(boltBucketPositions)
+		sub := root.Bucket(boltBackupKey(backupID))
+		if sub == nil {
+			return nil
+		}
+
+		return sub.ForEach(func(k, v []byte) error {
+			positions = append(positions, BlockPosition{
+				Offset: int(binary.BigEndian.Uint64(k)),
+				Hash:   string(v),
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortPositionsByOffset(positions)
+	return positions, nil
+}
+
+func (s *BoltStore) TotalBlocks() (int, error) {
+	var total int
+
+	err := s.handle().View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketBlocks).ForEach(func(k, v []byte) error {
+			total++
+			return nil
+		})
+	})
+
+	return total, err
+}
+
+func (s *BoltStore) LatestBackup(devicePath, backupType string) (*BackupRecord, error) {
+	var latest *BackupRecord
+
+	err := s.handle().View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucketBackups).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			rec := decodeBackupRecord(v)
+			if rec.DevicePath == devicePath && rec.BackupType == backupType {
+				latest = rec
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if latest == nil {
+		return nil, ErrBackupNotFound
+	}
+
+	return latest, nil
+}
+
+func (s *BoltStore) LatestBackupAnyType(devicePath string) (*BackupRecord, error) {
+	var latest *BackupRecord
+
+	err := s.handle().View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucketBackups).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			rec := decodeBackupRecord(v)
+			if rec.DevicePath == devicePath {
+				latest = rec
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if latest == nil {
+		return nil, ErrBackupNotFound
+	}
+
+	return latest, nil
+}
+
+func (s *BoltStore) GetBackup(id int64) (*BackupRecord, error) {
+	var rec *BackupRecord
+
+	err := s.handle().View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucketBackups).Get(boltBackupKey(id))
+		if v == nil {
+			return nil
+		}
+		rec = decodeBackupRecord(v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if rec == nil {
+		return nil, ErrBackupNotFound
+	}
+
+	return rec, nil
+}
+
+func (s *BoltStore) DeleteBackup(id int64) error {
+	return s.handle().Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltBucketBackups).Delete(boltBackupKey(id)); err != nil {
+			return fmt.Errorf("block: delete backup %d: %w", id, err)
+		}
+
+		if err := tx.Bucket(boltBucketPositions).DeleteBucket(boltBackupKey(id)); err != nil && err != bolt.ErrBucketNotFound {
+			return fmt.Errorf("block: delete positions for backup %d: %w", id, err)
+		}
+
+		return nil
+	})
+}
+
+func (s *BoltStore) ListBackups() ([]*BackupRecord, error) {
+	var records []*BackupRecord
+
+	err := s.handle().View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucketBackups).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			records = append(records, decodeBackupRecord(v))
+		}
+		return nil
+	})
+
+	return records, err
+}
+
+// Vacuum compacts the database into a fresh file by copying every bucket
+// into a new one, then swapping it into place. bbolt has no in-place VACUUM,
+// so unlike SQLiteStore.Vacuum this briefly closes and reopens the
+// underlying *bolt.DB. mu is held as a writer for the whole operation, not
+// just the close/rename/reopen swap: the copy itself reads a snapshot of
+// db, and a write that commits to db via handle().Update (e.g.
+// CreateBackup, RecordBlock) while the copy is in flight but not yet
+// reflected in it would otherwise be silently discarded when the rename
+// replaces the original file with that stale snapshot. This blocks
+// concurrent writers for the duration of the vacuum, the same tradeoff
+// SQLiteStore.Vacuum makes.
+func (s *BoltStore) Vacuum(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmpPath := s.path + ".compact"
+	_ = os.Remove(tmpPath)
+
+	dst, err := bolt.Open(tmpPath, 0600, nil)
+	if err != nil {
+		return fmt.Errorf("block: vacuum: open compact target: %w", err)
+	}
+
+	err = s.db.View(func(srcTx *bolt.Tx) error {
+		return dst.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				dstBkt, err := dstTx.CreateBucketIfNotExists(name)
+				if err != nil {
+					return err
+				}
+				return copyBoltBucket(dstBkt, b)
+			})
+		})
+	})
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("block: vacuum: compact: %w", err)
+	}
+
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("block: vacuum: close source: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("block: vacuum: replace: %w", err)
+	}
+
+	db, err := bolt.Open(s.path, 0600, nil)
+	if err != nil {
+		return fmt.Errorf("block: vacuum: reopen: %w", err)
+	}
+	s.db = db
+
+	return nil
+}
+
+// copyBoltBucket recursively copies src's keys and nested buckets into dst.
+func copyBoltBucket(dst *bolt.Bucket, src *bolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nestedSrc := src.Bucket(k)
+			nestedDst, err := dst.CreateBucketIfNotExists(k)
+			if err != nil {
+				return err
+			}
+			return copyBoltBucket(nestedDst, nestedSrc)
+		}
+		return dst.Put(k, v)
+	})
+}
+
+func (s *BoltStore) Stats() (*StoreStats, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("block: stats: %w", err)
+	}
+
+	bstats := s.handle().Stats()
+
+	var uniqueBlocks, totalPositions int64
+	err = s.handle().View(func(tx *bolt.Tx) error {
+		uniqueBlocks = int64(tx.Bucket(boltBucketBlocks).Stats().KeyN)
+		// KeyN also counts each backup's sub-bucket entry; BucketN counts
+		// those sub-buckets plus the positions bucket itself.
+		ps := tx.Bucket(boltBucketPositions).Stats()
+		totalPositions = int64(ps.KeyN - (ps.BucketN - 1))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("block: stats: %w", err)
+	}
+
+	return &StoreStats{
+		DBBytes:        info.Size(),
+		FreelistPages:  int64(bstats.FreePageN),
+		WALBytes:       0,
+		UniqueBlocks:   uniqueBlocks,
+		TotalPositions: totalPositions,
+	}, nil
+}
+
+func boltBackupKey(id int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(id))
+	return buf
+}
+
+func boltOffsetKey(offset int) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(offset))
+	return buf
+}
+
+// encodeBackupRecord/decodeBackupRecord use a fixed-width, dependency-free
+// encoding rather than gob/json so the on-disk format doesn't depend on
+// field tags or registration order.
+func encodeBackupRecord(rec *BackupRecord) []byte {
+	devicePath := []byte(rec.DevicePath)
+	backupType := []byte(rec.BackupType)
+
+	var parentID int64 = -1
+	if rec.ParentBackupID != nil {
+		parentID = *rec.ParentBackupID
+	}
+
+	buf := make([]byte, 0, 8+8+8+8+8+2+len(devicePath)+len(backupType))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(rec.ID))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(rec.BlockSize))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(rec.TotalBlocks))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(parentID))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(rec.CreatedAt.UnixNano()))
+	buf = append(buf, byte(len(devicePath)))
+	buf = append(buf, devicePath...)
+	buf = append(buf, byte(len(backupType)))
+	buf = append(buf, backupType...)
+
+	return buf
+}
+
+func decodeBackupRecord(buf []byte) *BackupRecord {
+	rec := &BackupRecord{
+		ID:          int64(binary.BigEndian.Uint64(buf[0:8])),
+		BlockSize:   int(binary.BigEndian.Uint64(buf[8:16])),
+		TotalBlocks: int(binary.BigEndian.Uint64(buf[16:24])),
+	}
+
+	if parentID := int64(binary.BigEndian.Uint64(buf[24:32])); parentID != -1 {
+		rec.ParentBackupID = &parentID
+	}
+
+	rec.CreatedAt = time.Unix(0, int64(binary.BigEndian.Uint64(buf[32:40]))).UTC()
+
+	i := 40
+	dpLen := int(buf[i])
+	i++
+	rec.DevicePath = string(buf[i : i+dpLen])
+	i += dpLen
+
+	btLen := int(buf[i])
+	i++
+	rec.BackupType = string(buf[i : i+btLen])
+
+	return rec
+}
+
+// encodeBlockOrigin/decodeBlockOrigin pack the (backup, offset) that first
+// wrote a block hash into the blocks bucket's value.
+func encodeBlockOrigin(backupID int64, offset int) []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[0:8], uint64(backupID))
+	binary.BigEndian.PutUint64(buf[8:16], uint64(offset))
+	return buf
+}
+
+func decodeBlockOrigin(buf []byte) (int64, int) {
+	return int64(binary.BigEndian.Uint64(buf[0:8])), int(binary.BigEndian.Uint64(buf[8:16]))
+}
+
+// Salt returns the store's persistent encryption salt, generating one on
+// first use.
+func (s *BoltStore) Salt() ([]byte, error) {
+	var salt []byte
+
+	err := s.handle().Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(boltBucketMeta)
+		if v := bkt.Get(boltMetaSaltKey); v != nil {
+			salt = append([]byte(nil), v...)
+			return nil
+		}
+
+		salt = make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return fmt.Errorf("block: salt: generate: %w", err)
+		}
+
+		return bkt.Put(boltMetaSaltKey, salt)
+	})
+
+	return salt, err
+}
+
+func (s *BoltStore) SetBackupKey(backupID int64, wrapped []byte) error {
+	return s.handle().Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketKeys).Put(boltBackupKey(backupID), wrapped)
+	})
+}
+
+func (s *BoltStore) BackupKey(backupID int64) ([]byte, error) {
+	var wrapped []byte
+
+	err := s.handle().View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(boltBucketKeys).Get(boltBackupKey(backupID)); v != nil {
+			wrapped = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if wrapped == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	return wrapped, nil
+}
+
+func (s *BoltStore) RewrapKeys(oldMasterKey, newMasterKey []byte) error {
+	return rewrapStoreKeys(s, oldMasterKey, newMasterKey)
+}
+
+func sortPositionsByOffset(positions []BlockPosition) {
+	for i := 1; i < len(positions); i++ {
+		for j := i; j > 0 && positions[j-1].Offset > positions[j].Offset; j-- {
+			positions[j-1], positions[j] = positions[j], positions[j-1]
+		}
+	}
+}
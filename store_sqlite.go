@@ -0,0 +1,470 @@
+package block
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// saltMetaKey is the store_meta row holding the persistent encryption salt.
+const saltMetaKey = "salt"
+
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+// SQLiteStore is the original Store implementation, backed by a sqlite3
+// database file. It requires cgo via github.com/mattn/go-sqlite3.
+type SQLiteStore struct {
+	path string
+	db   *sql.DB
+
+	// mu serializes Vacuum (which takes a write lock) against every other
+	// method (which take a read lock): sqlite's own VACUUM requires
+	// exclusive access to the database, so a query running concurrently
+	// from elsewhere in this process would otherwise risk a "database is
+	// locked" error, exactly the concurrent-with-live-traffic scenario
+	// Vacuum's doc comment (see store.go) says must be safe.
+	mu sync.RWMutex
+}
+
+// NewSQLiteStore opens (without creating tables) the sqlite database at path.
+// Call SetupDB before using the returned Store.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("block: open sqlite store: %w", err)
+	}
+
+	return &SQLiteStore{path: path, db: db}, nil
+}
+
+func (s *SQLiteStore) SetupDB() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS backups (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_path TEXT NOT NULL,
+			backup_type TEXT NOT NULL,
+			block_size INTEGER NOT NULL,
+			total_blocks INTEGER NOT NULL,
+			parent_backup_id INTEGER,
+			created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%S', 'now'))
+		)`,
+		`CREATE TABLE IF NOT EXISTS blocks (
+			hash TEXT PRIMARY KEY,
+			origin_backup_id INTEGER NOT NULL,
+			origin_offset INTEGER NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS positions (
+			backup_id INTEGER NOT NULL,
+			offset INTEGER NOT NULL,
+			hash TEXT NOT NULL,
+			PRIMARY KEY (backup_id, offset)
+		)`,
+		`CREATE TABLE IF NOT EXISTS store_meta (
+			key TEXT PRIMARY KEY,
+			value BLOB NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS backup_keys (
+			backup_id INTEGER PRIMARY KEY,
+			wrapped_key BLOB NOT NULL
+		)`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return fmt.Errorf("block: setup sqlite schema: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) Close() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) CreateBackup(rec *BackupRecord) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var parentID sql.NullInt64
+	if rec.ParentBackupID != nil {
+		parentID = sql.NullInt64{Int64: *rec.ParentBackupID, Valid: true}
+	}
+
+	res, err := s.db.Exec(
+		`INSERT INTO backups (device_path, backup_type, block_size, total_blocks, parent_backup_id) VALUES (?, ?, ?, ?, ?)`,
+		rec.DevicePath, rec.BackupType, rec.BlockSize, rec.TotalBlocks, parentID,
+	)
+	if err != nil {
+		return fmt.Errorf("block: create backup record: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("block: read backup id: %w", err)
+	}
+	rec.ID = id
+
+	created, err := s.getBackup(id)
+	if err != nil {
+		return fmt.Errorf("block: read created backup: %w", err)
+	}
+	rec.CreatedAt = created.CreatedAt
+
+	return nil
+}
+
+// RecordBlock is an atomic INSERT OR IGNORE, not a check-then-act: two
+// goroutines racing to record the same hash (e.g. a sparse device's
+// duplicate zeroed blocks, hashed concurrently within one backup's
+// BlockBufferSize) must not both see existed=false and both try to insert,
+// which would fail the second with a UNIQUE constraint violation.
+func (s *SQLiteStore) RecordBlock(hash string, originBackupID int64, originOffset int) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res, err := s.db.Exec(
+		`INSERT OR IGNORE INTO blocks (hash, origin_backup_id, origin_offset) VALUES (?, ?, ?)`,
+		hash, originBackupID, originOffset,
+	)
+	if err != nil {
+		return false, fmt.Errorf("block: record block: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("block: record block: %w", err)
+	}
+
+	return n == 0, nil
+}
+
+// BlockOrigin returns the backup and offset that first recorded hash.
+func (s *SQLiteStore) BlockOrigin(hash string) (int64, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var backupID, offset int64
+	err := s.db.QueryRow(`SELECT origin_backup_id, origin_offset FROM blocks WHERE hash = ?`, hash).Scan(&backupID, &offset)
+	if err == sql.ErrNoRows {
+		return 0, 0, ErrBlockNotFound
+	} else if err != nil {
+		return 0, 0, fmt.Errorf("block: block origin: %w", err)
+	}
+
+	return backupID, int(offset), nil
+}
+
+func (s *SQLiteStore) RecordPosition(backupID int64, offset int, hash string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.db.Exec(
+		`INSERT INTO positions (backup_id, offset, hash) VALUES (?, ?, ?)`,
+		backupID, offset, hash,
+	)
+	if err != nil {
+		return fmt.Errorf("block: record position: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) findBlockPositionsByBackup(backupID int64) ([]BlockPosition, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(
+		`SELECT offset, hash FROM positions WHERE backup_id = ? ORDER BY offset ASC`,
+		backupID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("block: find block positions: %w", err)
+	}
+	defer rows.Close()
+
+	var positions []BlockPosition
+	for rows.Next() {
+		var p BlockPosition
+		if err := rows.Scan(&p.Offset, &p.Hash); err != nil {
+			return nil, fmt.Errorf("block: scan position: %w", err)
+		}
+		positions = append(positions, p)
+	}
+
+	return positions, rows.Err()
+}
+
+func (s *SQLiteStore) TotalBlocks() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var total int
+	row := s.db.QueryRow(`SELECT COUNT(*) FROM blocks`)
+	if err := row.Scan(&total); err != nil {
+		return 0, fmt.Errorf("block: total blocks: %w", err)
+	}
+
+	return total, nil
+}
+
+func (s *SQLiteStore) LatestBackup(devicePath, backupType string) (*BackupRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	row := s.db.QueryRow(
+		`SELECT id, device_path, backup_type, block_size, total_blocks, parent_backup_id, created_at
+		 FROM backups WHERE device_path = ? AND backup_type = ?
+		 ORDER BY id DESC LIMIT 1`,
+		devicePath, backupType,
+	)
+
+	return scanBackupRecord(row)
+}
+
+func (s *SQLiteStore) LatestBackupAnyType(devicePath string) (*BackupRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	row := s.db.QueryRow(
+		`SELECT id, device_path, backup_type, block_size, total_blocks, parent_backup_id, created_at
+		 FROM backups WHERE device_path = ?
+		 ORDER BY id DESC LIMIT 1`,
+		devicePath,
+	)
+
+	return scanBackupRecord(row)
+}
+
+func (s *SQLiteStore) GetBackup(id int64) (*BackupRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.getBackup(id)
+}
+
+// getBackup is GetBackup without taking mu itself, so callers that already
+// hold it (CreateBackup, reading back the row it just inserted) can reuse
+// it without recursively locking a sync.RWMutex, which can deadlock against
+// a concurrent Vacuum waiting for the write lock.
+func (s *SQLiteStore) getBackup(id int64) (*BackupRecord, error) {
+	row := s.db.QueryRow(
+		`SELECT id, device_path, backup_type, block_size, total_blocks, parent_backup_id, created_at
+		 FROM backups WHERE id = ?`,
+		id,
+	)
+
+	return scanBackupRecord(row)
+}
+
+func (s *SQLiteStore) DeleteBackup(id int64) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, err := s.db.Exec(`DELETE FROM positions WHERE backup_id = ?`, id); err != nil {
+		return fmt.Errorf("block: delete positions for backup %d: %w", id, err)
+	}
+
+	if _, err := s.db.Exec(`DELETE FROM backups WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("block: delete backup %d: %w", id, err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) ListBackups() ([]*BackupRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(
+		`SELECT id, device_path, backup_type, block_size, total_blocks, parent_backup_id, created_at
+		 FROM backups ORDER BY id ASC`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("block: list backups: %w", err)
+	}
+	defer rows.Close()
+
+	var records []*BackupRecord
+	for rows.Next() {
+		rec, err := scanBackupRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+
+	return records, rows.Err()
+}
+
+// Salt returns the store's persistent encryption salt, generating one on
+// first use.
+func (s *SQLiteStore) Salt() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var salt []byte
+	err := s.db.QueryRow(`SELECT value FROM store_meta WHERE key = ?`, saltMetaKey).Scan(&salt)
+	if err == nil {
+		return salt, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("block: salt: %w", err)
+	}
+
+	salt = make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("block: salt: generate: %w", err)
+	}
+
+	if _, err := s.db.Exec(`INSERT OR IGNORE INTO store_meta (key, value) VALUES (?, ?)`, saltMetaKey, salt); err != nil {
+		return nil, fmt.Errorf("block: salt: save: %w", err)
+	}
+
+	// A concurrent caller may have generated and saved a different salt
+	// first; re-read so everyone converges on the one actually stored.
+	if err := s.db.QueryRow(`SELECT value FROM store_meta WHERE key = ?`, saltMetaKey).Scan(&salt); err != nil {
+		return nil, fmt.Errorf("block: salt: %w", err)
+	}
+
+	return salt, nil
+}
+
+func (s *SQLiteStore) SetBackupKey(backupID int64, wrapped []byte) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.db.Exec(
+		`INSERT INTO backup_keys (backup_id, wrapped_key) VALUES (?, ?)
+		 ON CONFLICT(backup_id) DO UPDATE SET wrapped_key = excluded.wrapped_key`,
+		backupID, wrapped,
+	)
+	if err != nil {
+		return fmt.Errorf("block: set backup key: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) BackupKey(backupID int64) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var wrapped []byte
+	err := s.db.QueryRow(`SELECT wrapped_key FROM backup_keys WHERE backup_id = ?`, backupID).Scan(&wrapped)
+	if err == sql.ErrNoRows {
+		return nil, ErrKeyNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("block: backup key: %w", err)
+	}
+
+	return wrapped, nil
+}
+
+func (s *SQLiteStore) RewrapKeys(oldMasterKey, newMasterKey []byte) error {
+	return rewrapStoreKeys(s, oldMasterKey, newMasterKey)
+}
+
+// Vacuum checkpoints and truncates the WAL, then runs VACUUM to reclaim
+// space freed by deleted backups. It takes mu for writing, blocking every
+// other method until it completes: sqlite's VACUUM needs exclusive access
+// to the database, so letting a query run concurrently from elsewhere in
+// this process risks a "database is locked" error.
+func (s *SQLiteStore) Vacuum(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.db.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return fmt.Errorf("block: vacuum: checkpoint: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, `VACUUM`); err != nil {
+		return fmt.Errorf("block: vacuum: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) Stats() (*StoreStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var pageCount, pageSize, freelistCount int64
+	if err := s.db.QueryRow(`PRAGMA page_count`).Scan(&pageCount); err != nil {
+		return nil, fmt.Errorf("block: stats: page_count: %w", err)
+	}
+	if err := s.db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return nil, fmt.Errorf("block: stats: page_size: %w", err)
+	}
+	if err := s.db.QueryRow(`PRAGMA freelist_count`).Scan(&freelistCount); err != nil {
+		return nil, fmt.Errorf("block: stats: freelist_count: %w", err)
+	}
+
+	var uniqueBlocks, totalPositions int64
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM blocks`).Scan(&uniqueBlocks); err != nil {
+		return nil, fmt.Errorf("block: stats: count blocks: %w", err)
+	}
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM positions`).Scan(&totalPositions); err != nil {
+		return nil, fmt.Errorf("block: stats: count positions: %w", err)
+	}
+
+	var walBytes int64
+	if info, err := os.Stat(s.path + "-wal"); err == nil {
+		walBytes = info.Size()
+	}
+
+	return &StoreStats{
+		DBBytes:        pageCount * pageSize,
+		FreelistPages:  freelistCount,
+		WALBytes:       walBytes,
+		UniqueBlocks:   uniqueBlocks,
+		TotalPositions: totalPositions,
+	}, nil
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanBackupRecord(row rowScanner) (*BackupRecord, error) {
+	rec, err := scanBackupRow(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrBackupNotFound
+		}
+		return nil, fmt.Errorf("block: scan backup record: %w", err)
+	}
+	return rec, nil
+}
+
+func scanBackupRow(row rowScanner) (*BackupRecord, error) {
+	var rec BackupRecord
+	var parentID sql.NullInt64
+	var createdAt string
+
+	if err := row.Scan(&rec.ID, &rec.DevicePath, &rec.BackupType, &rec.BlockSize, &rec.TotalBlocks, &parentID, &createdAt); err != nil {
+		return nil, err
+	}
+
+	if parentID.Valid {
+		rec.ParentBackupID = &parentID.Int64
+	}
+
+	if t, err := time.Parse(sqliteTimeLayout, createdAt); err == nil {
+		rec.CreatedAt = t
+	}
+
+	return &rec, nil
+}
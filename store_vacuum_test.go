@@ -0,0 +1,88 @@
+package block
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// TestStoreVacuum inserts and deletes many backups to grow the store's
+// freelist, then checks that Vacuum reclaims the space.
+func TestStoreVacuum(t *testing.T) {
+	for _, sb := range storeBackends {
+		t.Run(sb.name, func(t *testing.T) { testStoreVacuum(t, sb.dsn) })
+	}
+}
+
+func testStoreVacuum(t *testing.T, dsn string) {
+	store := newTestStore(t, dsn)
+	defer store.Close()
+
+	setup(store)
+	defer cleanup(t)
+
+	const numBackups = 300
+	const positionsPerBackup = 10
+
+	var ids []int64
+	for i := 0; i < numBackups; i++ {
+		rec := &BackupRecord{
+			DevicePath:  "assets/pg.ext4",
+			BackupType:  backupTypeFull,
+			BlockSize:   1048576,
+			TotalBlocks: positionsPerBackup,
+		}
+		if err := store.CreateBackup(rec); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, rec.ID)
+
+		for j := 0; j < positionsPerBackup; j++ {
+			hash := fmt.Sprintf("hash-%d-%d", i, j)
+			if _, err := store.RecordBlock(hash, rec.ID, j); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.RecordPosition(rec.ID, j, hash); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	before, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range ids {
+		if err := store.DeleteBackup(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	afterDelete, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if afterDelete.FreelistPages <= before.FreelistPages {
+		t.Fatalf("expected freelist to grow after deleting %d backups, got %d (was %d)",
+			numBackups, afterDelete.FreelistPages, before.FreelistPages)
+	}
+
+	if err := store.Vacuum(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	afterVacuum, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if afterVacuum.DBBytes >= afterDelete.DBBytes {
+		t.Fatalf("expected file size to shrink after Vacuum, was %d bytes, still %d bytes",
+			afterDelete.DBBytes, afterVacuum.DBBytes)
+	}
+	if afterVacuum.FreelistPages >= afterDelete.FreelistPages {
+		t.Fatalf("expected freelist to shrink after Vacuum, was %d pages, still %d pages",
+			afterDelete.FreelistPages, afterVacuum.FreelistPages)
+	}
+}
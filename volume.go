@@ -0,0 +1,79 @@
+package block
+
+import (
+	"fmt"
+	"os"
+)
+
+// volume wraps the device (or device image) a Backup reads blocks from.
+//
+// DevicePath is resolved lazily, at reopen time, rather than once at
+// construction: Backup.Run calls reopen before every run so that a caller
+// (or test) that changes DevicePath between runs gets blocks read from the
+// new path, not a stale cached handle to the old one.
+type volume struct {
+	DevicePath string
+
+	f    *os.File
+	size int64
+}
+
+func openVolume(devicePath string) (*volume, error) {
+	v := &volume{DevicePath: devicePath}
+	if err := v.reopen(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// reopen (re)opens DevicePath, replacing any previously open handle. Call it
+// before reading blocks whenever DevicePath may have changed since the last
+// open.
+func (v *volume) reopen() error {
+	f, err := os.Open(v.DevicePath)
+	if err != nil {
+		return fmt.Errorf("block: open device %s: %w", v.DevicePath, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("block: stat device %s: %w", v.DevicePath, err)
+	}
+
+	if v.f != nil {
+		v.f.Close()
+	}
+	v.f = f
+	v.size = info.Size()
+	return nil
+}
+
+func (v *volume) Close() error {
+	return v.f.Close()
+}
+
+// readBlockAt reads a single block of blockSize bytes at the given block
+// offset (not byte offset). The final block may be shorter than blockSize if
+// the device size isn't an exact multiple of it.
+func (v *volume) readBlockAt(offset, blockSize int) ([]byte, error) {
+	buf := make([]byte, blockSize)
+
+	n, err := v.f.ReadAt(buf, int64(offset)*int64(blockSize))
+	if err != nil && n == 0 {
+		return nil, fmt.Errorf("block: read block %d: %w", offset, err)
+	}
+
+	return buf[:n], nil
+}
+
+// totalBlocks returns how many blocks of blockSize it takes to cover the
+// volume, rounding up.
+func (v *volume) totalBlocks(blockSize int) int {
+	total := v.size / int64(blockSize)
+	if v.size%int64(blockSize) != 0 {
+		total++
+	}
+
+	return int(total)
+}